@@ -2,63 +2,230 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"loadBalancer/pkg/admin"
 	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/certs"
 	"loadBalancer/pkg/config"
 	"loadBalancer/pkg/handlers"
 	"loadBalancer/pkg/middleware"
+	"loadBalancer/pkg/proxyproto"
+	"loadBalancer/pkg/tcpproxy"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-const (
-	RoundRobinAlg = "round_robin"
-	RandomAlg     = "random"
-	LeastConnAlg  = "least_conn"
-)
+var errRoutesChanged = errors.New("routes can not be changed without restart, except canary percent")
 
 func main() {
 	cfgPath := flag.String("config", "config.json", "Path to config file")
+	watchInterval := flag.Duration("watch", 0, "Interval of config file change checks, 0 - reload only on SIGHUP")
 	flag.Parse()
 
+	// Все логи, включая log.Printf, пишутся в JSON через slog
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	cfg, err := config.LoadConfig(*cfgPath)
 	if err != nil {
 		log.Fatalf("Не удалось загрузить конфиг: %v", err)
 	}
-
-	var strategy backend.BalancerStrategy
-	switch cfg.Algorithm {
-	case RoundRobinAlg:
-		strategy = &backend.RoundRobinStrategy{}
-	case RandomAlg:
-		strategy = &backend.RandomStrategy{}
-	case LeastConnAlg:
-		strategy = &backend.LeastConnectionsStrategy{}
-	default:
-		log.Fatalf("Неизвестный алгоритм балансировки: %s", cfg.Algorithm)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Некорректный конфиг: %v", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	// Фоновые горутины (health check, перечитывание конфига), которые ждем при завершении
+	background := &sync.WaitGroup{}
+
+	pools, err := backend.NewPools(cfg)
+	if err != nil {
+		log.Fatalf("Не удалось создать пулы: %v", err)
+	}
+	backend.RegisterPoolMetrics(pools)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		pools.HealthCheck(ctx, cfg)
+	}()
 
-	pool := backend.NewBackendPool(cfg.Backends)
-	pool.SetStrategy(strategy)
-	go pool.HealthCheck(ctx, time.Duration(cfg.HealthCheckInterval)*time.Second)
+	handler, err := handlers.NewRouter(cfg.Routes, pools, handlers.ProxyOptions{
+		Sticky:  handlers.NewStickySessions(cfg.StickySession),
+		Retry:   handlers.NewRetryPolicy(cfg.Retry),
+		Hedge:   handlers.NewHedgePolicy(cfg.Hedging),
+		Upgrade: handlers.NewUpgradePolicy(cfg.Upgrade),
+	})
+	if err != nil {
+		log.Fatalf("Некорректные маршруты: %v", err)
+	}
 
-	handler := handlers.SetupProxyHandler(pool)
+	ready := &atomic.Bool{}
+	servers := []*http.Server{{
+		Addr:    fmt.Sprintf(":%d", cfg.ListenPort),
+		Handler: middleware.Panic(middleware.LoggingMiddleware(handler)),
+	}}
+	// Имена серверов для логов
+	names := map[*http.Server]string{servers[0]: "Load Balancer"}
 
-	http.Handle("/", middleware.Panic(middleware.LoggingMiddleware(handler)))
+	// gRPC клиенты внутри сети обычно подключаются по HTTP/2 без TLS
+	if cfg.H2C {
+		servers[0].Protocols = new(http.Protocols)
+		servers[0].Protocols.SetHTTP1(true)
+		servers[0].Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	if cfg.TLS.ListenPort != 0 {
+		store, err := certs.NewStore(cfg.TLS)
+		if err != nil {
+			log.Fatalf("Не удалось загрузить TLS сертификаты: %v", err)
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			store.Watch(ctx)
+		}()
+
+		tlsServer := &http.Server{
+			Addr:      fmt.Sprintf(":%d", cfg.TLS.ListenPort),
+			Handler:   middleware.Panic(middleware.LoggingMiddleware(handler)),
+			TLSConfig: store.TLSConfig(),
+		}
+		servers = append(servers, tlsServer)
+		names[tlsServer] = "Load Balancer (TLS)"
+		if cfg.TLS.RedirectHTTP {
+			servers[0].Handler = middleware.Panic(middleware.LoggingMiddleware(handlers.RedirectHTTPS(cfg.TLS.ListenPort)))
+		}
+	}
+
+	// Shutdown не ждет соединений после Upgrade (WebSocket), поэтому они закрываются
+	// сразу, иначе продолжали бы работать до выхода из процесса
+	for _, srv := range servers {
+		srv.RegisterOnShutdown(pools.CloseUpgraded)
+	}
 
+	// Служебные порты (admin API, readiness и метрики) вызываются напрямую, а не через
+	// внешний L4 прокси, поэтому PROXY protocol на них не разбирается
+	internal := make(map[*http.Server]bool)
+	if cfg.Admin.ListenPort != 0 {
+		if cfg.Admin.Token == "" {
+			log.Fatalf("Для admin API необходимо задать admin.token")
+		}
+		adminServer := &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.Admin.ListenPort),
+			Handler: middleware.Panic(middleware.LoggingMiddleware(admin.NewHandler(pools, handler, cfg.Admin.Token))),
+		}
+		servers = append(servers, adminServer)
+		names[adminServer] = "admin API"
+		internal[adminServer] = true
+	}
+	// Readiness и метрики на своем порту, чтобы не зависеть от admin API и его токена.
+	// Сервер идет последним: при завершении readiness отвечает 503, пока дорабатывают запросы
+	statusServer := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.MetricsListenPort),
+		Handler: middleware.Panic(admin.NewStatusHandler(ready)),
+	}
+	servers = append(servers, statusServer)
+	names[statusServer] = "readiness и метрик"
+	internal[statusServer] = true
+
+	// Все порты занимаются до того, как readiness начнет отвечать 200, чтобы трафик не пошел
+	// на еще не открытые порты, а занятый порт останавливал запуск сразу
+	listeners := make(map[*http.Server]net.Listener, len(servers))
+	for _, srv := range servers {
+		pp := cfg.ProxyProtocol
+		if internal[srv] {
+			pp = config.ProxyProtocolConfig{}
+		}
+		ln, err := listen(srv.Addr, pp)
+		if err != nil {
+			log.Fatalf("Listen(): %v", err)
+		}
+		listeners[srv] = ln
+	}
+	// L4 listeners проксируют соединения в пулы без разбора HTTP
+	proxies := make([]*tcpproxy.Proxy, 0, len(cfg.TCPListeners))
+	tcpListeners := make(map[*tcpproxy.Proxy]net.Listener, len(cfg.TCPListeners))
+	for _, lc := range cfg.TCPListeners {
+		p := tcpproxy.NewProxy(lc, pools.Get(lc.Pool))
+		ln, err := listen(p.Addr(), cfg.ProxyProtocol)
+		if err != nil {
+			log.Fatalf("TCP listener %s: %v", p.Name(), err)
+		}
+		proxies = append(proxies, p)
+		tcpListeners[p] = ln
+	}
+
+	for _, srv := range servers {
+		go func(srv *http.Server, ln net.Listener) {
+			log.Printf("Запуск %s на %s ...", names[srv], srv.Addr)
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("ListenAndServe(): %v", err)
+			}
+		}(srv, listeners[srv])
+	}
+	for _, p := range proxies {
+		go func(p *tcpproxy.Proxy, ln net.Listener) {
+			log.Printf("Запуск TCP listener %s на %s ...", p.Name(), p.Addr())
+			if err := p.Serve(ln); err != nil && err != tcpproxy.ErrProxyClosed {
+				log.Fatalf("TCP listener %s: %v", p.Name(), err)
+			}
+		}(p, tcpListeners[p])
+	}
+	ready.Store(true)
+
+	// Перечитывание конфига по SIGHUP и (опционально) по изменению файла. Все перезагрузки
+	// выполняются в одной горутине, поэтому не пересекаются друг с другом
+	reload := make(chan struct{}, 1)
+	requestReload := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		addr := fmt.Sprintf(":%d", cfg.ListenPort)
-		log.Printf("Запуск Load Balancer на %s ...", addr)
-		if err := http.ListenAndServe(addr, nil); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe(): %v", err)
+		for range hup {
+			requestReload()
+		}
+	}()
+	if *watchInterval > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			config.Watch(ctx, *cfgPath, *watchInterval, requestReload)
+		}()
+	}
+	// Актуальный конфиг меняет только горутина перезагрузки, остальные читают его через current
+	current := &atomic.Pointer[config.Config]{}
+	current.Store(cfg)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+				current.Store(reloadConfig(*cfgPath, pools, handler, current.Load()))
+			}
 		}
 	}()
 
@@ -66,6 +233,118 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Завершение работы Load Balancer...")
+
+	// Сначала проваливаем readiness, чтобы внешний балансировщик перестал слать трафик,
+	// затем перестаем принимать соединения и ждем завершения начатых запросов
+	ready.Store(false)
+	if !shutdown(servers, proxies, time.Duration(current.Load().ShutdownTimeout)*time.Second) {
+		log.Println("Не все запросы завершились до истечения shutdown_timeout")
+		cancel()
+		background.Wait()
+		os.Exit(1)
+	}
+
 	cancel()
+	background.Wait()
 	log.Println("Load Balancer завершил работу")
 }
+
+// Открывает listener на addr. Если задан proxy_protocol, у соединений от доверенных адресов
+// адрес клиента берется из заголовка PROXY
+func listen(addr string, pp config.ProxyProtocolConfig) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || len(pp.TrustedCIDRs) == 0 {
+		return ln, err
+	}
+
+	trusted, err := proxyproto.ParseCIDRs(pp.TrustedCIDRs)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	pp = pp.WithDefaults()
+	return proxyproto.NewListener(ln, trusted, time.Duration(pp.HeaderTimeoutMs)*time.Millisecond), nil
+}
+
+// Останавливает серверы по очереди и TCP listeners, дожидаясь завершения начатых запросов
+// и соединений не дольше timeout. Порт readiness идет последним, чтобы readiness отвечал 503,
+// пока проксируемые запросы дорабатывают. Возвращает false, если за отведенное время дождаться не удалось
+func shutdown(servers []*http.Server, proxies []*tcpproxy.Proxy, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// TCP соединения (например, к базам данных) могут жить долго, поэтому их ждем
+	// параллельно с HTTP серверами, а не перед ними. Принудительно закрытые по таймауту
+	// L4 соединения не считаются ошибкой: у них нет границ запросов, которых можно дождаться
+	proxiesDone := &sync.WaitGroup{}
+	for _, p := range proxies {
+		proxiesDone.Add(1)
+		go func(p *tcpproxy.Proxy) {
+			defer proxiesDone.Done()
+			if err := p.Shutdown(ctx); err != nil {
+				log.Printf("TCP listener %s: оставшиеся соединения закрыты принудительно: %v", p.Name(), err)
+			}
+		}(p)
+	}
+
+	drained := true
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown %s: %v", srv.Addr, err)
+			srv.Close()
+			drained = false
+		}
+	}
+	proxiesDone.Wait()
+
+	return drained
+}
+
+// Перечитывает конфиг и применяет его к пулам. При ошибке остается старый конфиг
+func reloadConfig(path string, pools *backend.Pools, router *handlers.Router, current *config.Config) *config.Config {
+	log.Printf("Перечитывание конфига %s ...", path)
+
+	cfg, err := config.LoadConfig(path)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	// Таблица маршрутов строится при старте, на лету меняется только доля canary
+	if err == nil && !reflect.DeepEqual(withoutCanaryPercent(cfg.Routes), withoutCanaryPercent(current.Routes)) {
+		err = errRoutesChanged
+	}
+	if err == nil {
+		err = pools.ApplyConfig(cfg)
+	}
+	if err != nil {
+		log.Printf("Конфиг не применен, продолжаем работу со старым: %v", err)
+		return current
+	}
+
+	if cfg.ListenPort != current.ListenPort || cfg.Admin != current.Admin || cfg.MetricsListenPort != current.MetricsListenPort ||
+		cfg.StickySession != current.StickySession || cfg.Upgrade != current.Upgrade || cfg.H2C != current.H2C ||
+		!reflect.DeepEqual(cfg.Retry, current.Retry) || !reflect.DeepEqual(cfg.Hedging, current.Hedging) {
+		log.Println("Изменения listen_port, admin, metrics_listen_port, sticky_session, upgrade, h2c, retry и hedging применяются только после перезапуска")
+	}
+	if !reflect.DeepEqual(cfg.TCPListeners, current.TCPListeners) || !reflect.DeepEqual(cfg.ProxyProtocol, current.ProxyProtocol) {
+		log.Println("Изменения tcp_listeners и proxy_protocol применяются только после перезапуска")
+	}
+	if !reflect.DeepEqual(cfg.TLS, current.TLS) {
+		log.Println("Изменения tls применяются только после перезапуска, файлы сертификатов перечитываются автоматически")
+	}
+	router.ApplyCanary(current.Routes, cfg.Routes)
+	log.Println("Конфиг применен")
+	return cfg
+}
+
+func withoutCanaryPercent(routes []config.RouteConfig) []config.RouteConfig {
+	result := make([]config.RouteConfig, len(routes))
+	for i, rc := range routes {
+		if rc.Canary != nil {
+			canary := *rc.Canary
+			canary.Percent = 0
+			rc.Canary = &canary
+		}
+		result[i] = rc
+	}
+	return result
+}
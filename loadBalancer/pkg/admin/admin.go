@@ -0,0 +1,285 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/handlers"
+	"loadBalancer/pkg/metrics"
+)
+
+type Handler struct {
+	Pools  *backend.Pools
+	Router *handlers.Router
+	token  string
+}
+
+// Admin API для управления пулами во время работы. Методы работают с пулом из параметра
+// ?pool=, без него - с пулом по умолчанию:
+//
+//	GET    /api/pools           - список пулов
+//	GET    /api/backends        - список бэкэндов с их состоянием
+//	POST   /api/backends        - добавить бэкэнд {"url": "...", "weight": 1}
+//	DELETE /api/backends?url=   - удалить бэкэнд
+//	POST   /api/backends/drain  - перевести бэкэнд в drain {"url": "...", "drain": true}
+//	GET    /api/algorithm       - текущий алгоритм балансировки
+//	PUT    /api/algorithm       - сменить алгоритм {"algorithm": "...", "hash": {...}}
+//	GET    /api/retry-budget    - состояние бюджета повторов
+//	GET    /api/routes          - маршруты и доли canary
+//	PUT    /api/routes/{name}/canary - сменить долю canary {"percent": 10}
+func NewHandler(pools *backend.Pools, router *handlers.Router, token string) http.Handler {
+	h := &Handler{Pools: pools, Router: router, token: token}
+
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/pools", h.ListPools)
+	api.HandleFunc("GET /api/backends", h.ListBackends)
+	api.HandleFunc("POST /api/backends", h.AddBackend)
+	api.HandleFunc("DELETE /api/backends", h.RemoveBackend)
+	api.HandleFunc("POST /api/backends/drain", h.DrainBackend)
+	api.HandleFunc("GET /api/algorithm", h.GetAlgorithm)
+	api.HandleFunc("PUT /api/algorithm", h.SetAlgorithm)
+	api.HandleFunc("GET /api/retry-budget", h.RetryBudget)
+	api.HandleFunc("GET /api/routes", h.ListRoutes)
+	api.HandleFunc("PUT /api/routes/{name}/canary", h.SetCanary)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", h.auth(api))
+
+	return mux
+}
+
+// Обработчик порта metrics_listen_port, не зависит от admin API и работает без авторизации:
+//
+//	GET    /ready               - readiness, 503 после начала завершения работы
+//	GET    /metrics             - метрики в формате Prometheus
+func NewStatusHandler(ready *atomic.Bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			responseJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+			return
+		}
+		responseJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	mux.Handle("GET /metrics", metrics.Handler())
+	return mux
+}
+
+func (h *Handler) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			responseJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Пул из параметра ?pool=, при ошибке ответ уже записан и возвращается nil
+func (h *Handler) pool(w http.ResponseWriter, r *http.Request) *backend.BackendPool {
+	name := r.URL.Query().Get("pool")
+	if name == "" {
+		name = config.DefaultPool
+	}
+
+	pool := h.Pools.Get(name)
+	if pool == nil {
+		responseError(w, http.StatusNotFound, backend.ErrPoolNotFound)
+	}
+	return pool
+}
+
+func (h *Handler) ListPools(w http.ResponseWriter, r *http.Request) {
+	type poolStatus struct {
+		Name      string `json:"name"`
+		Algorithm string `json:"algorithm"`
+		Protocol  string `json:"protocol"`
+		Backends  int    `json:"backends"`
+	}
+
+	pools := h.Pools.All()
+	statuses := make([]poolStatus, 0, len(pools))
+	for _, pool := range pools {
+		pool.RLock()
+		n := len(pool.Backends)
+		pool.RUnlock()
+		statuses = append(statuses, poolStatus{Name: pool.Name, Algorithm: pool.GetAlgorithm(), Protocol: pool.Protocol(), Backends: n})
+	}
+
+	responseJSON(w, http.StatusOK, statuses)
+}
+
+func (h *Handler) ListBackends(w http.ResponseWriter, r *http.Request) {
+	pool := h.pool(w, r)
+	if pool == nil {
+		return
+	}
+
+	pool.RLock()
+	backends := make([]*backend.Backend, len(pool.Backends))
+	copy(backends, pool.Backends)
+	pool.RUnlock()
+
+	statuses := make([]backend.BackendStatus, 0, len(backends))
+	for _, b := range backends {
+		statuses = append(statuses, b.Status())
+	}
+
+	responseJSON(w, http.StatusOK, map[string]any{
+		"pool":      pool.Name,
+		"algorithm": pool.GetAlgorithm(),
+		"backends":  statuses,
+	})
+}
+
+func (h *Handler) AddBackend(w http.ResponseWriter, r *http.Request) {
+	pool := h.pool(w, r)
+	if pool == nil {
+		return
+	}
+
+	var bc config.BackendConfig
+	if err := json.NewDecoder(r.Body).Decode(&bc); err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	b, err := pool.AddBackend(bc)
+	switch {
+	case errors.Is(err, backend.ErrBackendExists):
+		responseError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	responseJSON(w, http.StatusCreated, b.Status())
+}
+
+func (h *Handler) RemoveBackend(w http.ResponseWriter, r *http.Request) {
+	pool := h.pool(w, r)
+	if pool == nil {
+		return
+	}
+
+	rawURL := r.URL.Query().Get("url")
+	if err := pool.RemoveBackend(rawURL); err != nil {
+		responseError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) DrainBackend(w http.ResponseWriter, r *http.Request) {
+	pool := h.pool(w, r)
+	if pool == nil {
+		return
+	}
+
+	var req struct {
+		URL   string `json:"url"`
+		Drain bool   `json:"drain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	b := pool.FindBackend(req.URL)
+	if b == nil {
+		responseError(w, http.StatusNotFound, backend.ErrBackendNotFound)
+		return
+	}
+	b.SetDraining(req.Drain)
+
+	responseJSON(w, http.StatusOK, b.Status())
+}
+
+func (h *Handler) GetAlgorithm(w http.ResponseWriter, r *http.Request) {
+	pool := h.pool(w, r)
+	if pool == nil {
+		return
+	}
+
+	responseJSON(w, http.StatusOK, map[string]string{"algorithm": pool.GetAlgorithm()})
+}
+
+func (h *Handler) SetAlgorithm(w http.ResponseWriter, r *http.Request) {
+	pool := h.pool(w, r)
+	if pool == nil {
+		return
+	}
+
+	var req struct {
+		Algorithm string            `json:"algorithm"`
+		Hash      config.HashConfig `json:"hash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := pool.SetAlgorithm(req.Algorithm, req.Hash); err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, map[string]string{"algorithm": req.Algorithm})
+}
+
+func (h *Handler) RetryBudget(w http.ResponseWriter, r *http.Request) {
+	pool := h.pool(w, r)
+	if pool == nil {
+		return
+	}
+
+	responseJSON(w, http.StatusOK, pool.RetryBudget().Status())
+}
+
+func (h *Handler) ListRoutes(w http.ResponseWriter, r *http.Request) {
+	responseJSON(w, http.StatusOK, h.Router.Routes())
+}
+
+func (h *Handler) SetCanary(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Percent int `json:"percent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.Router.SetCanaryPercent(r.PathValue("name"), req.Percent)
+	switch {
+	case errors.Is(err, handlers.ErrRouteNotFound), errors.Is(err, handlers.ErrNoCanary):
+		responseError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	responseJSON(w, http.StatusOK, map[string]any{"route": r.PathValue("name"), "percent": req.Percent})
+}
+
+func responseError(w http.ResponseWriter, code int, err error) {
+	responseJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func responseJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Admin: failed to write response: %v", err)
+	}
+}
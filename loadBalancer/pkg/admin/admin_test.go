@@ -0,0 +1,198 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/handlers"
+)
+
+const testToken = "secret"
+
+func newTestHandler(t *testing.T, backendURL string) (http.Handler, *backend.Pools) {
+	t.Helper()
+	cfg := &config.Config{
+		Algorithm: backend.RoundRobinAlg,
+		Backends:  []config.BackendConfig{{URL: backendURL, Weight: 1}},
+		Pools: map[string]config.PoolConfig{
+			"canary": {Backends: []config.BackendConfig{{URL: backendURL, Weight: 1}}},
+		},
+	}
+	pools, err := backend.NewPools(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := handlers.NewRouter([]config.RouteConfig{
+		{Name: "web", PathPrefix: "/", Pool: config.DefaultPool, Canary: &config.CanaryConfig{Pool: "canary", Percent: 5}},
+		{Name: "plain", PathPrefix: "/plain", Pool: config.DefaultPool},
+	}, pools, handlers.ProxyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(pools, router, testToken), pools
+}
+
+func do(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuth(t *testing.T) {
+	h, _ := newTestHandler(t, "http://127.0.0.1:1")
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "no header", want: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer other", want: http.StatusUnauthorized},
+		{name: "no bearer prefix", header: testToken, want: http.StatusUnauthorized},
+		{name: "ok", header: "Bearer " + testToken, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/pools", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("code = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackends(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	h, pools := newTestHandler(t, "http://127.0.0.1:1")
+	pool := pools.Get(config.DefaultPool)
+
+	steps := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{name: "add", method: "POST", target: "/api/backends", body: `{"url": "` + srv.URL + `", "weight": 3}`, want: http.StatusCreated},
+		{name: "add duplicate", method: "POST", target: "/api/backends", body: `{"url": "` + srv.URL + `"}`, want: http.StatusConflict},
+		{name: "add invalid url", method: "POST", target: "/api/backends", body: `{"url": "not a url"}`, want: http.StatusBadRequest},
+		{name: "add bad json", method: "POST", target: "/api/backends", body: `{`, want: http.StatusBadRequest},
+		{name: "drain", method: "POST", target: "/api/backends/drain", body: `{"url": "` + srv.URL + `", "drain": true}`, want: http.StatusOK},
+		{name: "drain unknown", method: "POST", target: "/api/backends/drain", body: `{"url": "http://unknown.local"}`, want: http.StatusNotFound},
+		{name: "remove", method: "DELETE", target: "/api/backends?url=http://127.0.0.1:1", want: http.StatusNoContent},
+		{name: "remove unknown", method: "DELETE", target: "/api/backends?url=http://127.0.0.1:1", want: http.StatusNotFound},
+		{name: "unknown pool", method: "GET", target: "/api/backends?pool=missing", want: http.StatusNotFound},
+	}
+	for _, s := range steps {
+		if rec := do(h, s.method, s.target, s.body); rec.Code != s.want {
+			t.Fatalf("%s: code = %d, want %d: %s", s.name, rec.Code, s.want, rec.Body)
+		}
+	}
+
+	b := pool.FindBackend(srv.URL)
+	if b == nil || pool.FindBackend("http://127.0.0.1:1") != nil {
+		t.Fatal("pool membership not updated")
+	}
+	if b.GetWeight() != 3 || !b.IsDraining() || !b.IsAlive() {
+		t.Errorf("backend weight=%d draining=%t alive=%t", b.GetWeight(), b.IsDraining(), b.IsAlive())
+	}
+
+	var list struct {
+		Pool     string                  `json:"pool"`
+		Backends []backend.BackendStatus `json:"backends"`
+	}
+	rec := do(h, "GET", "/api/backends", "")
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Pool != config.DefaultPool || len(list.Backends) != 1 || list.Backends[0].URL != srv.URL {
+		t.Errorf("list = %+v", list)
+	}
+
+	// Остальные пулы не затронуты
+	if got := len(pools.Get("canary").Backends); got != 1 {
+		t.Errorf("canary pool has %d backends, want 1", got)
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	h, pools := newTestHandler(t, "http://127.0.0.1:1")
+
+	if rec := do(h, "PUT", "/api/algorithm?pool=canary", `{"algorithm": "least_conn"}`); rec.Code != http.StatusOK {
+		t.Fatalf("code = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(h, "PUT", "/api/algorithm", `{"algorithm": "unknown"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown algorithm: code = %d", rec.Code)
+	}
+
+	if got := pools.Get("canary").GetAlgorithm(); got != backend.LeastConnAlg {
+		t.Errorf("canary algorithm = %s", got)
+	}
+	rec := do(h, "GET", "/api/algorithm", "")
+	if !strings.Contains(rec.Body.String(), backend.RoundRobinAlg) {
+		t.Errorf("default pool algorithm changed: %s", rec.Body)
+	}
+}
+
+func TestRoutesCanary(t *testing.T) {
+	h, _ := newTestHandler(t, "http://127.0.0.1:1")
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{name: "set", path: "/api/routes/web/canary", body: `{"percent": 25}`, want: http.StatusOK},
+		{name: "out of range", path: "/api/routes/web/canary", body: `{"percent": 101}`, want: http.StatusBadRequest},
+		{name: "route without canary", path: "/api/routes/plain/canary", body: `{"percent": 10}`, want: http.StatusNotFound},
+		{name: "unknown route", path: "/api/routes/missing/canary", body: `{"percent": 10}`, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := do(h, "PUT", tt.path, tt.body); rec.Code != tt.want {
+			t.Errorf("%s: code = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	var routes []handlers.RouteStatus
+	if err := json.NewDecoder(do(h, "GET", "/api/routes", "").Body).Decode(&routes); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0].Canary == nil || routes[0].Canary.Percent != 25 || routes[1].Canary != nil {
+		t.Errorf("routes = %+v", routes)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	var ready atomic.Bool
+	h := NewStatusHandler(&ready)
+
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusOK} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+		if rec.Code != want {
+			t.Errorf("ready=%t: code = %d, want %d", ready.Load(), rec.Code, want)
+		}
+		ready.Store(true)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("metrics code = %d", rec.Code)
+	}
+}
@@ -2,7 +2,13 @@ package backend
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/metrics"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -12,61 +18,321 @@ import (
 	"time"
 )
 
+var (
+	ErrBackendExists     = errors.New("backend already exists")
+	ErrBackendNotFound   = errors.New("backend not found")
+	ErrInvalidBackendURL = errors.New("invalid backend url")
+	ErrUnknownAlgorithm  = errors.New("unknown balancing algorithm")
+	ErrPoolNotFound      = errors.New("pool not found")
+	ErrPoolsChanged      = errors.New("pools can not be added or removed without restart")
+)
+
 type Backend struct {
 	URL        *url.URL
 	Alive      bool
+	Draining   bool
 	ActiveConn int64
+	Weight     int
+	Breaker    *CircuitBreaker
 	*sync.RWMutex
+
+	// Peak EWMA времени ответа бэкэнда (наносекунды) и время последнего замера
+	latencyEWMA  float64
+	latencyStamp time.Time
+
+	// Счетчики подряд идущих успешных и неуспешных активных проверок
+	healthChecked  bool
+	healthSuccess  int
+	healthFailures int
+
+	// Состояние пассивной проверки (outlier detection)
+	outlier outlierState
+
+	// Соединения после HTTP Upgrade
+	upgrade upgradeState
+	// Соединения TCP listeners
+	tcpConns map[io.Closer]struct{}
 }
 
+// Время, за которое вес старого значения EWMA уменьшается в e раз
+const latencyDecay = 10 * time.Second
+
 func (b *Backend) SetAlive(alive bool) {
 	b.Lock()
 	b.Alive = alive
 	b.Unlock()
-	log.Printf("Backend %s alive=%t", b.URL, alive)
+	log.Printf("Backend %s alive=%t breaker=%s", b.URL, alive, b.Breaker.State())
 }
 func (b *Backend) IncConn()         { atomic.AddInt64(&b.ActiveConn, 1) }
 func (b *Backend) DecConn()         { atomic.AddInt64(&b.ActiveConn, -1) }
 func (b *Backend) ConnCount() int64 { return atomic.LoadInt64(&b.ActiveConn) }
+
+// Учитывает соединение, только если их меньше max (max <= 0 - без ограничения)
+func (b *Backend) TryIncConn(max int64) bool {
+	for {
+		n := atomic.LoadInt64(&b.ActiveConn)
+		if max > 0 && n >= max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.ActiveConn, n, n+1) {
+			return true
+		}
+	}
+}
 func (b *Backend) IsAlive() bool {
 	b.RLock()
 	defer b.RUnlock()
 	return b.Alive
 }
 
+func (b *Backend) SetWeight(weight int) {
+	b.Lock()
+	b.Weight = weight
+	b.Unlock()
+}
+
+func (b *Backend) GetWeight() int {
+	b.RLock()
+	defer b.RUnlock()
+	return b.Weight
+}
+
+// Бэкэнд в режиме drain не получает новых запросов, но уже начатые обрабатывает
+func (b *Backend) SetDraining(draining bool) {
+	b.Lock()
+	b.Draining = draining
+	b.Unlock()
+	log.Printf("Backend %s draining=%t", b.URL, draining)
+
+	// Обычные запросы завершатся сами, а upgraded и L4 соединения могут жить сколько угодно
+	if draining {
+		b.closeLongLived()
+	}
+}
+
+func (b *Backend) IsDraining() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.Draining
+}
+
+// Может ли бэкэнд принимать новые запросы
+func (b *Backend) IsAvailable() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.Alive && !b.Draining && !time.Now().Before(b.outlier.ejectedUntil) &&
+		b.Breaker.State() != BreakerOpen
+}
+
+// Учитывает очередной замер времени ответа. Пики принимаются сразу, а снижение
+// сглаживается экспоненциально с учетом времени, прошедшего с прошлого замера
+func (b *Backend) ObserveLatency(d time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	rtt := float64(d)
+	if b.latencyStamp.IsZero() || rtt > b.latencyEWMA {
+		b.latencyEWMA = rtt
+	} else {
+		w := math.Exp(-float64(now.Sub(b.latencyStamp)) / float64(latencyDecay))
+		b.latencyEWMA = b.latencyEWMA*w + rtt*(1-w)
+	}
+	b.latencyStamp = now
+}
+
+func (b *Backend) Latency() time.Duration {
+	b.RLock()
+	defer b.RUnlock()
+	return time.Duration(b.latencyEWMA)
+}
+
+// Снимок состояния бэкэнда для отдачи наружу (admin API, метрики)
+type BackendStatus struct {
+	URL        string  `json:"url"`
+	Alive      bool    `json:"alive"`
+	Draining   bool    `json:"draining"`
+	Ejected    bool    `json:"ejected"`
+	Breaker    string  `json:"breaker"`
+	ActiveConn int64   `json:"active_conn"`
+	Weight     int     `json:"weight"`
+	LatencyMs  float64 `json:"latency_ms"`
+}
+
+func (b *Backend) Status() BackendStatus {
+	return BackendStatus{
+		URL:        b.URL.String(),
+		Alive:      b.IsAlive(),
+		Draining:   b.IsDraining(),
+		Ejected:    b.IsEjected(),
+		Breaker:    b.Breaker.State().String(),
+		ActiveConn: b.ConnCount(),
+		Weight:     b.GetWeight(),
+		LatencyMs:  float64(b.Latency()) / float64(time.Millisecond),
+	}
+}
+
 type BackendPool struct {
-	Backends []*Backend
-	Strategy BalancerStrategy
+	Name             string
+	Backends         []*Backend
+	Strategy         BalancerStrategy
+	Algorithm        string
+	hash             config.HashConfig
+	healthCheck      config.HealthCheckConfig
+	outlierDetection config.OutlierConfig
+	circuitBreaker   config.BreakerConfig
+	retryBudget      *RetryBudget
+	latency          *latencyWindow
+	upstreamTLS      config.UpstreamTLSConfig
+	upstreamFiles    string
+	protocol         string
+	transport        http.RoundTripper
+	ejectMu          sync.Mutex
+	// Упорядочивает изменения состава пула (admin API и перечитывание конфига), чтобы
+	// изменение, сделанное во время активной проверки новых бэкэндов, не потерялось
+	membershipMu sync.Mutex
+	// Бэкэнды и алгоритм из последнего примененного конфига. При перечитывании применяется
+	// только разница между старым и новым конфигом, поэтому изменения через admin API
+	// (добавленные и удаленные бэкэнды, алгоритм) не откатываются
+	configBackends  map[string]struct{}
+	configAlgorithm string
+	configHash      config.HashConfig
+	intervalCh      chan time.Duration
 	*sync.RWMutex
 }
 
-func NewBackendPool(urls []string) *BackendPool {
-	const bufferChSize = 100
-
-	pool := &BackendPool{RWMutex: &sync.RWMutex{}}
-	for _, u := range urls {
-		parsed, err := url.Parse(u)
+func NewBackendPool(backends []config.BackendConfig) *BackendPool {
+	pool := &BackendPool{
+		healthCheck:      config.HealthCheckConfig{}.WithDefaults(),
+		outlierDetection: config.OutlierConfig{}.WithDefaults(),
+		circuitBreaker:   config.BreakerConfig{}.WithDefaults(),
+		retryBudget:      NewRetryBudget(config.RetryBudgetConfig{}),
+		latency:          &latencyWindow{},
+		transport:        http.DefaultTransport,
+		protocol:         config.ProtocolHTTP,
+		intervalCh:       make(chan time.Duration, 1),
+		RWMutex:          &sync.RWMutex{},
+	}
+	pool.configBackends = make(map[string]struct{}, len(backends))
+	for _, bc := range backends {
+		b, err := pool.newBackend(bc)
 		if err != nil {
 			// Можно сделать, поскольку выполняется при инициализации приложения
-			log.Fatalf("Неверный URL бэкэнда: %s", u)
+			log.Fatalf("Неверный URL бэкэнда: %s", bc.URL)
 		}
-		b := &Backend{URL: parsed, Alive: true, RWMutex: &sync.RWMutex{}}
 		pool.Backends = append(pool.Backends, b)
+		pool.configBackends[b.URL.String()] = struct{}{}
 	}
 	return pool
 }
 
-func (p *BackendPool) NextBackend() *Backend {
+func (p *BackendPool) newBackend(bc config.BackendConfig) (*Backend, error) {
+	parsed, err := url.Parse(bc.URL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBackendURL, bc.URL)
+	}
+	return &Backend{
+		URL:     parsed,
+		Alive:   true,
+		Weight:  normalizeWeight(bc.Weight),
+		Breaker: NewCircuitBreaker(parsed.String(), p.circuitBreaker),
+		RWMutex: &sync.RWMutex{},
+	}, nil
+}
+
+func normalizeWeight(weight int) int {
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+// Добавляет бэкэнд в работающий пул. Перед добавлением бэкэнд проверяется
+// активной проверкой, чтобы на мертвый бэкэнд сразу не пошел трафик
+func (p *BackendPool) AddBackend(bc config.BackendConfig) (*Backend, error) {
+	p.membershipMu.Lock()
+	defer p.membershipMu.Unlock()
+
 	p.RLock()
-	if p.Strategy == nil {
-		return nil
+	b, err := p.newBackend(bc)
+	hc := p.healthCheck
+	p.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if p.FindBackend(b.URL.String()) != nil {
+		return nil, ErrBackendExists
+	}
+
+	b.recordHealthCheck(checkBackend(b, hc, p.Transport()) == nil, hc.Rise, hc.Fall)
+
+	p.Lock()
+	defer p.Unlock()
+	for _, other := range p.Backends {
+		if other.URL.String() == b.URL.String() {
+			return nil, ErrBackendExists
+		}
 	}
+	p.Backends = append(p.Backends, b)
+	log.Printf("Backend %s added", b.URL)
+	return b, nil
+}
+
+// Удаляет бэкэнд из пула, уже начатые на нем запросы дорабатывают
+func (p *BackendPool) RemoveBackend(rawURL string) error {
+	p.membershipMu.Lock()
+	defer p.membershipMu.Unlock()
+
+	p.Lock()
+	var removed *Backend
+	for i, b := range p.Backends {
+		if b.URL.String() != rawURL {
+			continue
+		}
+		p.Backends = append(p.Backends[:i], p.Backends[i+1:]...)
+		removed = b
+		break
+	}
+	p.Unlock()
+
+	if removed == nil {
+		return ErrBackendNotFound
+	}
+	removed.closeLongLived()
+	log.Printf("Backend %s removed", rawURL)
+	return nil
+}
+
+func (p *BackendPool) NextBackend(req *http.Request) *Backend {
+	p.RLock()
+	strategy := p.Strategy
 	p.RUnlock()
-	return p.Strategy.NextBackend(p)
+	if strategy == nil {
+		return nil
+	}
+	return strategy.NextBackend(p, req)
 }
 
-func (p *BackendPool) getAliveBackends() []*Backend {
+type excludedKey struct{}
+
+// Выбирает бэкэнд стратегией, не рассматривая уже опробованные (например, при retry)
+func (p *BackendPool) NextBackendExcluding(req *http.Request, excluded map[*Backend]struct{}) *Backend {
+	if len(excluded) == 0 {
+		return p.NextBackend(req)
+	}
+	return p.NextBackend(req.WithContext(context.WithValue(req.Context(), excludedKey{}, excluded)))
+}
+
+// Живые бэкэнды, которые могут принимать запросы, без исключенных для данного запроса
+func (p *BackendPool) getAliveBackends(req *http.Request) []*Backend {
 	var alive []*Backend
+	var excluded map[*Backend]struct{}
+	if req != nil {
+		excluded, _ = req.Context().Value(excludedKey{}).(map[*Backend]struct{})
+	}
 
 	p.RLock()
 	backends := make([]*Backend, len(p.Backends))
@@ -74,7 +340,10 @@ func (p *BackendPool) getAliveBackends() []*Backend {
 	p.RUnlock()
 
 	for _, b := range backends {
-		if b.IsAlive() {
+		if _, ok := excluded[b]; ok {
+			continue
+		}
+		if b.IsAvailable() {
 			alive = append(alive, b)
 		}
 	}
@@ -82,12 +351,85 @@ func (p *BackendPool) getAliveBackends() []*Backend {
 	return alive
 }
 
+func (p *BackendPool) FindBackend(rawURL string) *Backend {
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, b := range p.Backends {
+		if b.URL.String() == rawURL {
+			return b
+		}
+	}
+	return nil
+}
+
+// Возвращает первый бэкэнд пула, для которого match вернул true
+func (p *BackendPool) FindBackendFunc(match func(*Backend) bool) *Backend {
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, b := range p.Backends {
+		if match(b) {
+			return b
+		}
+	}
+	return nil
+}
+
 func (p *BackendPool) SetStrategy(s BalancerStrategy) {
 	p.Lock()
 	p.Strategy = s
 	p.Unlock()
 }
 
+// Меняет алгоритм балансировки, безопасно вызывать во время обработки запросов
+func (p *BackendPool) SetAlgorithm(algorithm string, hash config.HashConfig) error {
+	strategy, err := NewStrategy(algorithm, hash)
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	p.Strategy = strategy
+	p.Algorithm = algorithm
+	p.hash = hash
+	p.Unlock()
+	log.Printf("Balancing algorithm set to %s", algorithm)
+	return nil
+}
+
+func (p *BackendPool) GetAlgorithm() string {
+	p.RLock()
+	defer p.RUnlock()
+	return p.Algorithm
+}
+
+func (p *BackendPool) SetCircuitBreaker(cfg config.BreakerConfig) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.circuitBreaker = cfg.WithDefaults()
+	for _, b := range p.Backends {
+		b.Breaker.Configure(cfg)
+	}
+}
+
+func (p *BackendPool) SetHealthCheck(hc config.HealthCheckConfig) {
+	p.Lock()
+	p.healthCheck = hc.WithDefaults()
+	p.Unlock()
+}
+
+// Меняет интервал запущенной HealthCheck
+func (p *BackendPool) SetHealthCheckInterval(interval time.Duration) {
+	// Если предыдущее значение еще не прочитано, заменяем его новым
+	select {
+	case <-p.intervalCh:
+	default:
+	}
+	p.intervalCh <- interval
+}
+
 // Функция проверки работоспособности сервера, если бы я реализовывал эти сервисы, то
 // Реализовал бы в них ручку проверки состояние по типу /api/state/ или /api/health/,
 // Вызывая которую сервис присылает ответ StatukOK, или же 500
@@ -105,8 +447,11 @@ func (p *BackendPool) HealthCheck(ctx context.Context, interval time.Duration) {
 			log.Printf("HealthCheck stopped: %v", ctx.Err())
 			return
 		case <-ticker.C:
+			p.reloadUpstreamFiles()
 			PingServers(p, wg)
-
+		case interval = <-p.intervalCh:
+			ticker.Reset(interval)
+			log.Printf("HealthCheck interval set to %v", interval)
 		}
 	}
 }
@@ -115,6 +460,8 @@ func PingServers(pool *BackendPool, wg *sync.WaitGroup) {
 	pool.RLock()
 	backends := make([]*Backend, len(pool.Backends))
 	copy(backends, pool.Backends)
+	hc := pool.healthCheck
+	transport := pool.transport
 	pool.RUnlock()
 
 	wg.Add(len(backends))
@@ -122,10 +469,14 @@ func PingServers(pool *BackendPool, wg *sync.WaitGroup) {
 		go func(b *Backend) {
 			defer wg.Done()
 			// Пингуем сервер
-			client := http.Client{Timeout: 2 * time.Second}
-			_, err := client.Get(b.URL.String())
-			alive := err == nil
-			b.SetAlive(alive)
+			err := checkBackend(b, hc, transport)
+			if err != nil {
+				log.Printf("Health check %s failed: %v", b.URL, err)
+				metrics.HealthChecks.Inc(b.URL.String(), "failure")
+			} else {
+				metrics.HealthChecks.Inc(b.URL.String(), "success")
+			}
+			b.recordHealthCheck(err == nil, hc.Rise, hc.Fall)
 		}(b)
 	}
 
@@ -133,16 +484,48 @@ func PingServers(pool *BackendPool, wg *sync.WaitGroup) {
 }
 
 // Реализуем паттерн Стратегия, чтобы в runtime можно было подменять при необходимости алгоритм выбора сервера
+// Запрос передается стратегии, чтобы она могла учитывать его при выборе (например, хешировать)
 type BalancerStrategy interface {
-	NextBackend(pool *BackendPool) *Backend
+	NextBackend(pool *BackendPool, req *http.Request) *Backend
+}
+
+const (
+	RoundRobinAlg         = "round_robin"
+	RandomAlg             = "random"
+	LeastConnAlg          = "least_conn"
+	WeightedRoundRobinAlg = "weighted_round_robin"
+	ConsistentHashAlg     = "consistent_hash"
+	P2CAlg                = "p2c"
+	PeakEWMAAlg           = "peak_ewma"
+)
+
+func NewStrategy(algorithm string, hash config.HashConfig) (BalancerStrategy, error) {
+	switch algorithm {
+	case RoundRobinAlg:
+		return &RoundRobinStrategy{}, nil
+	case RandomAlg:
+		return &RandomStrategy{}, nil
+	case LeastConnAlg:
+		return &LeastConnectionsStrategy{}, nil
+	case WeightedRoundRobinAlg:
+		return &WeightedRoundRobinStrategy{}, nil
+	case ConsistentHashAlg:
+		return NewConsistentHashStrategy(hash.Key, hash.Name, hash.VirtualNodes), nil
+	case P2CAlg:
+		return &P2CStrategy{}, nil
+	case PeakEWMAAlg:
+		return &PeakEWMAStrategy{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
 }
 
 type RoundRobinStrategy struct {
 	counter uint64
 }
 
-func (r *RoundRobinStrategy) NextBackend(pool *BackendPool) *Backend {
-	alive := pool.getAliveBackends()
+func (r *RoundRobinStrategy) NextBackend(pool *BackendPool, req *http.Request) *Backend {
+	alive := pool.getAliveBackends(req)
 	if len(alive) == 0 {
 		return nil
 	}
@@ -150,10 +533,57 @@ func (r *RoundRobinStrategy) NextBackend(pool *BackendPool) *Backend {
 	return alive[idx]
 }
 
+// Плавный взвешенный round robin (как в nginx): на каждом шаге текущий вес каждого
+// живого бэкэнда увеличивается на его вес, выбирается бэкэнд с максимальным текущим
+// весом, и из его текущего веса вычитается суммарный вес живых бэкэндов
+type WeightedRoundRobinStrategy struct {
+	current map[*Backend]int
+	mu      sync.Mutex
+}
+
+func (w *WeightedRoundRobinStrategy) NextBackend(pool *BackendPool, req *http.Request) *Backend {
+	alive := pool.getAliveBackends(req)
+	if len(alive) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Веса учитываются только среди живых, поэтому состояние умерших сбрасываем,
+	// чтобы после возвращения они не получили накопленный "долг"
+	aliveSet := make(map[*Backend]struct{}, len(alive))
+	for _, b := range alive {
+		aliveSet[b] = struct{}{}
+	}
+	if w.current == nil {
+		w.current = make(map[*Backend]int)
+	}
+	for b := range w.current {
+		if _, ok := aliveSet[b]; !ok {
+			delete(w.current, b)
+		}
+	}
+
+	var best *Backend
+	total := 0
+	for _, b := range alive {
+		weight := b.GetWeight()
+		w.current[b] += weight
+		total += weight
+		if best == nil || w.current[b] > w.current[best] {
+			best = b
+		}
+	}
+	w.current[best] -= total
+
+	return best
+}
+
 type RandomStrategy struct{}
 
-func (r *RandomStrategy) NextBackend(pool *BackendPool) *Backend {
-	alive := pool.getAliveBackends()
+func (r *RandomStrategy) NextBackend(pool *BackendPool, req *http.Request) *Backend {
+	alive := pool.getAliveBackends(req)
 	if len(alive) == 0 {
 		return nil
 	}
@@ -162,8 +592,8 @@ func (r *RandomStrategy) NextBackend(pool *BackendPool) *Backend {
 
 type LeastConnectionsStrategy struct{}
 
-func (l *LeastConnectionsStrategy) NextBackend(pool *BackendPool) *Backend {
-	alive := pool.getAliveBackends()
+func (l *LeastConnectionsStrategy) NextBackend(pool *BackendPool, req *http.Request) *Backend {
+	alive := pool.getAliveBackends(req)
 	if len(alive) == 0 {
 		return nil
 	}
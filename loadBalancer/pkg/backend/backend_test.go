@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"loadBalancer/pkg/config"
+)
+
+func TestWeightedRoundRobinSequence(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		want    string
+	}{
+		{name: "5/1/1", weights: []int{5, 1, 1}, want: "aabacaa"},
+		{name: "equal", weights: []int{1, 1, 1}, want: "abc"},
+		{name: "2/1", weights: []int{2, 1}, want: "aba"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := map[string]string{}
+			var backends []config.BackendConfig
+			for i, w := range tt.weights {
+				u := "http://" + string(rune('a'+i)) + ".local"
+				names[u] = string(rune('a' + i))
+				backends = append(backends, config.BackendConfig{URL: u, Weight: w})
+			}
+			pool := NewBackendPool(backends)
+			pool.Strategy = &WeightedRoundRobinStrategy{}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			// Последовательность повторяется с периодом в суммарный вес, проверяем два периода
+			var got string
+			for i := 0; i < 2*len(tt.want); i++ {
+				got += names[pool.NextBackend(req).URL.String()]
+			}
+			if want := tt.want + tt.want; got != want {
+				t.Errorf("sequence = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestNextBackendExcluding(t *testing.T) {
+	pool := NewBackendPool([]config.BackendConfig{
+		{URL: "http://a.local", Weight: 1},
+		{URL: "http://b.local", Weight: 1},
+	})
+	pool.Strategy = &RoundRobinStrategy{}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	tried := map[*Backend]struct{}{}
+	for i := 0; i < len(pool.Backends); i++ {
+		b := pool.NextBackendExcluding(req, tried)
+		if b == nil {
+			t.Fatalf("attempt %d: no backend", i)
+		}
+		if _, ok := tried[b]; ok {
+			t.Fatalf("attempt %d: backend %s returned twice", i, b.URL)
+		}
+		tried[b] = struct{}{}
+	}
+	if b := pool.NextBackendExcluding(req, tried); b != nil {
+		t.Errorf("all backends tried, got %s", b.URL)
+	}
+}
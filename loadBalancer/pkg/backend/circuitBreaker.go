@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitBreaker struct {
+	name string
+	cfg  config.BreakerConfig
+
+	state     BreakerState
+	failures  int
+	successes int
+	inFlight  int
+	openedAt  time.Time
+	mu        sync.Mutex
+}
+
+func NewCircuitBreaker(name string, cfg config.BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{name: name, cfg: cfg.WithDefaults()}
+}
+
+func (cb *CircuitBreaker) Configure(cfg config.BreakerConfig) {
+	cb.mu.Lock()
+	cb.cfg = cfg.WithDefaults()
+	cb.mu.Unlock()
+}
+
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.refresh()
+	return cb.state
+}
+
+// Можно ли отправить запрос. В half-open одновременно пропускается не больше
+// half_open_max_requests пробных запросов, на каждый разрешенный запрос нужно вызвать Record
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.refresh()
+
+	switch cb.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if cb.inFlight >= cb.cfg.HalfOpenMaxRequests {
+			return false
+		}
+		cb.inFlight++
+	}
+	return true
+}
+
+func (cb *CircuitBreaker) Record(ok bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerClosed:
+		if ok {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.cfg.FailureThreshold {
+			cb.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if cb.inFlight > 0 {
+			cb.inFlight--
+		}
+		if !ok {
+			cb.setState(BreakerOpen)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.cfg.SuccessThreshold {
+			cb.setState(BreakerClosed)
+		}
+	}
+}
+
+// Освобождает место пробного запроса без учета результата: запрос был отменен
+// самим балансировщиком (например, проигравшая копия hedged запроса)
+func (cb *CircuitBreaker) Release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerHalfOpen && cb.inFlight > 0 {
+		cb.inFlight--
+	}
+}
+
+// Переход open -> half-open происходит лениво при обращении к breaker
+func (cb *CircuitBreaker) refresh() {
+	timeout := time.Duration(cb.cfg.OpenTimeoutMs) * time.Millisecond
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= timeout {
+		cb.setState(BreakerHalfOpen)
+	}
+}
+
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	cb.state = state
+	cb.failures = 0
+	cb.successes = 0
+	cb.inFlight = 0
+	if state == BreakerOpen {
+		cb.openedAt = time.Now()
+	}
+	log.Printf("Backend %s circuit breaker=%s", cb.name, state)
+}
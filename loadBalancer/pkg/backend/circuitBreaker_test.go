@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	type step struct {
+		// Действие: allow, ok, fail, expire (истек open_timeout)
+		action string
+		allow  bool
+		state  BreakerState
+	}
+	tests := []struct {
+		name  string
+		cfg   config.BreakerConfig
+		steps []step
+	}{
+		{
+			name: "closed to open to half-open to closed",
+			cfg:  config.BreakerConfig{FailureThreshold: 3, HalfOpenMaxRequests: 1, SuccessThreshold: 1},
+			steps: []step{
+				{action: "fail", state: BreakerClosed},
+				{action: "ok", state: BreakerClosed},
+				{action: "fail", state: BreakerClosed},
+				{action: "fail", state: BreakerClosed},
+				{action: "fail", state: BreakerOpen},
+				{action: "allow", allow: false, state: BreakerOpen},
+				{action: "expire", state: BreakerHalfOpen},
+				{action: "allow", allow: true, state: BreakerHalfOpen},
+				// Пробный запрос уже в полете, второй не пропускается
+				{action: "allow", allow: false, state: BreakerHalfOpen},
+				{action: "ok", state: BreakerClosed},
+				{action: "allow", allow: true, state: BreakerClosed},
+			},
+		},
+		{
+			name: "failed probe reopens",
+			cfg:  config.BreakerConfig{FailureThreshold: 1},
+			steps: []step{
+				{action: "fail", state: BreakerOpen},
+				{action: "expire", state: BreakerHalfOpen},
+				{action: "allow", allow: true, state: BreakerHalfOpen},
+				{action: "fail", state: BreakerOpen},
+				{action: "allow", allow: false, state: BreakerOpen},
+			},
+		},
+		{
+			name: "released probe frees the slot",
+			cfg:  config.BreakerConfig{FailureThreshold: 1},
+			steps: []step{
+				{action: "fail", state: BreakerOpen},
+				{action: "expire", state: BreakerHalfOpen},
+				{action: "allow", allow: true, state: BreakerHalfOpen},
+				{action: "release", state: BreakerHalfOpen},
+				{action: "allow", allow: true, state: BreakerHalfOpen},
+			},
+		},
+		{
+			name: "success threshold",
+			cfg:  config.BreakerConfig{FailureThreshold: 1, HalfOpenMaxRequests: 2, SuccessThreshold: 2},
+			steps: []step{
+				{action: "fail", state: BreakerOpen},
+				{action: "expire", state: BreakerHalfOpen},
+				{action: "allow", allow: true, state: BreakerHalfOpen},
+				{action: "allow", allow: true, state: BreakerHalfOpen},
+				{action: "allow", allow: false, state: BreakerHalfOpen},
+				{action: "ok", state: BreakerHalfOpen},
+				{action: "ok", state: BreakerClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewCircuitBreaker("test", tt.cfg)
+			for i, s := range tt.steps {
+				switch s.action {
+				case "allow":
+					if got := cb.Allow(); got != s.allow {
+						t.Fatalf("step %d: Allow = %t, want %t", i, got, s.allow)
+					}
+				case "ok", "fail":
+					cb.Record(s.action == "ok")
+				case "release":
+					cb.Release()
+				case "expire":
+					cb.mu.Lock()
+					cb.openedAt = time.Now().Add(-time.Hour)
+					cb.mu.Unlock()
+				}
+				if got := cb.State(); got != s.state {
+					t.Fatalf("step %d (%s): state = %s, want %s", i, s.action, got, s.state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"hash/crc32"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	HashKeyIP     = "ip"
+	HashKeyHeader = "header"
+	HashKeyCookie = "cookie"
+	HashKeyPath   = "path"
+
+	defaultVirtualNodes = 100
+)
+
+// Консистентное хеширование: каждый бэкэнд пула представлен на кольце несколькими
+// виртуальными узлами, запрос попадает на первый живой узел по часовой стрелке от хеша
+// ключа. Кольцо строится по всем бэкэндам пула (а не только по живым), поэтому когда
+// бэкэнд умирает, на соседей переезжают только его ключи, остальные остаются на месте
+type ConsistentHashStrategy struct {
+	key          string
+	keyName      string
+	virtualNodes int
+
+	mu      sync.RWMutex
+	members []*Backend
+	ring    []uint32
+	nodes   map[uint32]*Backend
+}
+
+// key - источник ключа хеширования (ip, header, cookie, path), keyName - имя заголовка
+// или cookie. Если ключ в запросе отсутствует, используется IP клиента
+func NewConsistentHashStrategy(key, keyName string, virtualNodes int) *ConsistentHashStrategy {
+	if key == "" {
+		key = HashKeyIP
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	return &ConsistentHashStrategy{
+		key:          key,
+		keyName:      keyName,
+		virtualNodes: virtualNodes,
+	}
+}
+
+func (c *ConsistentHashStrategy) NextBackend(pool *BackendPool, req *http.Request) *Backend {
+	alive := pool.getAliveBackends(req)
+	if len(alive) == 0 {
+		return nil
+	}
+	aliveSet := make(map[*Backend]struct{}, len(alive))
+	for _, b := range alive {
+		aliveSet[b] = struct{}{}
+	}
+
+	pool.RLock()
+	members := make([]*Backend, len(pool.Backends))
+	copy(members, pool.Backends)
+	pool.RUnlock()
+
+	c.mu.RLock()
+	if !sameMembers(c.members, members) {
+		c.mu.RUnlock()
+		c.rebuild(members)
+		c.mu.RLock()
+	}
+	defer c.mu.RUnlock()
+
+	if len(c.ring) == 0 {
+		return nil
+	}
+
+	h := crc32.ChecksumIEEE([]byte(c.hashKey(req)))
+	idx := sort.Search(len(c.ring), func(i int) bool { return c.ring[i] >= h })
+	for i := 0; i < len(c.ring); i++ {
+		b := c.nodes[c.ring[(idx+i)%len(c.ring)]]
+		if _, ok := aliveSet[b]; ok {
+			return b
+		}
+	}
+
+	return nil
+}
+
+func (c *ConsistentHashStrategy) rebuild(members []*Backend) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sameMembers(c.members, members) {
+		return
+	}
+
+	ring := make([]uint32, 0, len(members)*c.virtualNodes)
+	nodes := make(map[uint32]*Backend, len(members)*c.virtualNodes)
+	for _, b := range members {
+		for i := 0; i < c.virtualNodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "-" + b.URL.String()))
+			// При коллизии хешей узел остается за бэкэндом, добавленным первым
+			if _, ok := nodes[h]; ok {
+				continue
+			}
+			nodes[h] = b
+			ring = append(ring, h)
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i] < ring[j] })
+
+	c.members = members
+	c.ring = ring
+	c.nodes = nodes
+}
+
+func (c *ConsistentHashStrategy) hashKey(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	return RequestKey(req, c.key, c.keyName)
+}
+
+// Ключ запроса из источника key (ip, header, cookie, path), name - имя заголовка или cookie.
+// Если ключ в запросе отсутствует, используется IP клиента
+func RequestKey(req *http.Request, key, name string) string {
+	switch key {
+	case HashKeyHeader:
+		if v := req.Header.Get(name); v != "" {
+			return v
+		}
+	case HashKeyCookie:
+		if cookie, err := req.Cookie(name); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	case HashKeyPath:
+		if req.URL != nil {
+			return req.URL.Path
+		}
+	}
+
+	return clientIP(req)
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func sameMembers(a, b []*Backend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"loadBalancer/pkg/config"
+)
+
+func TestConsistentHashStability(t *testing.T) {
+	urls := []string{"http://a.local", "http://b.local", "http://c.local", "http://d.local"}
+	tests := []struct {
+		name   string
+		change func(pool *BackendPool, url string)
+	}{
+		{name: "removed", change: func(pool *BackendPool, url string) {
+			if err := pool.RemoveBackend(url); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "dead", change: func(pool *BackendPool, url string) {
+			pool.FindBackend(url).SetAlive(false)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backends []config.BackendConfig
+			for _, u := range urls {
+				backends = append(backends, config.BackendConfig{URL: u, Weight: 1})
+			}
+			pool := NewBackendPool(backends)
+			pool.Strategy = NewConsistentHashStrategy(HashKeyHeader, "X-User", 0)
+
+			pick := func(key string) string {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set("X-User", key)
+				return pool.NextBackend(req).URL.String()
+			}
+
+			before := map[string]string{}
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("user-%d", i)
+				before[key] = pick(key)
+			}
+
+			gone := urls[1]
+			tt.change(pool, gone)
+
+			moved := 0
+			for key, was := range before {
+				now := pick(key)
+				switch {
+				case now == gone:
+					t.Fatalf("key %s still routed to %s", key, gone)
+				case was == gone:
+					moved++
+				case now != was:
+					t.Errorf("key %s moved from %s to %s", key, was, now)
+				}
+			}
+			if moved == 0 {
+				t.Errorf("no keys were routed to %s before the change", gone)
+			}
+		})
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		keyArg string
+		header string
+		cookie string
+		want   string
+	}{
+		{name: "ip", key: HashKeyIP, want: "192.0.2.1"},
+		{name: "header", key: HashKeyHeader, keyArg: "X-User", header: "alice", want: "alice"},
+		{name: "missing header falls back to ip", key: HashKeyHeader, keyArg: "X-User", want: "192.0.2.1"},
+		{name: "cookie", key: HashKeyCookie, keyArg: "uid", cookie: "bob", want: "bob"},
+		{name: "path", key: HashKeyPath, want: "/api/items"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/items", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.header != "" {
+				req.Header.Set(tt.keyArg, tt.header)
+			}
+			if tt.cookie != "" {
+				req.Header.Set("Cookie", tt.keyArg+"="+tt.cookie)
+			}
+			if got := RequestKey(req, tt.key, tt.keyArg); got != tt.want {
+				t.Errorf("RequestKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
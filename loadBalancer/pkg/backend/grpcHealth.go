@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+// Метод Check из gRPC Health Checking Protocol
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+var errInvalidGRPCMessage = errors.New("invalid grpc message")
+
+// Значения HealthCheckResponse.ServingStatus
+var grpcServingStatus = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// Вызывает grpc.health.v1.Health/Check. Сообщения протокола состоят из одного поля,
+// поэтому кодируются вручную, без зависимости от grpc и protobuf
+func checkGRPC(b *Backend, hc config.HealthCheckConfig, transport http.RoundTripper) error {
+	client := http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(hc.TimeoutMs) * time.Millisecond,
+	}
+
+	// HealthCheckRequest{service = 1}
+	var msg []byte
+	if hc.Service != "" {
+		msg = append(msg, 0x0a)
+		msg = binary.AppendUvarint(msg, uint64(len(hc.Service)))
+		msg = append(msg, hc.Service...)
+	}
+	// Кадр gRPC: флаг сжатия и длина сообщения
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	req, err := http.NewRequest(http.MethodPost, b.URL.JoinPath(grpcHealthPath).String(), bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodySize))
+	if err != nil {
+		return err
+	}
+
+	// Статус вызова приходит в трейлерах, а при ошибке без сообщения - в заголовках
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		message := resp.Trailer.Get("Grpc-Message")
+		if message == "" {
+			message = resp.Header.Get("Grpc-Message")
+		}
+		return fmt.Errorf("grpc status %q: %s", status, message)
+	}
+
+	serving, err := parseServingStatus(body)
+	if err != nil {
+		return err
+	}
+	if serving != 1 {
+		return fmt.Errorf("grpc health status %s", grpcServingStatus[serving])
+	}
+	return nil
+}
+
+// Достает поле status = 1 из кадра с HealthCheckResponse, остальные поля пропускает
+func parseServingStatus(frame []byte) (uint64, error) {
+	if len(frame) < 5 || frame[0] != 0 {
+		return 0, errInvalidGRPCMessage
+	}
+	msg := frame[5:]
+	if uint32(len(msg)) < binary.BigEndian.Uint32(frame[1:5]) {
+		return 0, errInvalidGRPCMessage
+	}
+	msg = msg[:binary.BigEndian.Uint32(frame[1:5])]
+
+	// Поле не передается, если равно значению по умолчанию (UNKNOWN)
+	var status uint64
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, errInvalidGRPCMessage
+		}
+		msg = msg[n:]
+
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0, errInvalidGRPCMessage
+			}
+			msg = msg[n:]
+			if key>>3 == 1 {
+				status = v
+			}
+		case 1:
+			if len(msg) < 8 {
+				return 0, errInvalidGRPCMessage
+			}
+			msg = msg[8:]
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0, errInvalidGRPCMessage
+			}
+			msg = msg[n+int(l):]
+		case 5:
+			if len(msg) < 4 {
+				return 0, errInvalidGRPCMessage
+			}
+			msg = msg[4:]
+		default:
+			return 0, errInvalidGRPCMessage
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Кадр gRPC с сообщением msg
+func grpcFrame(msg []byte) []byte {
+	return append(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg))), msg...)
+}
+
+func TestParseServingStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		want    uint64
+		wantErr bool
+	}{
+		{name: "serving", frame: grpcFrame([]byte{0x08, 0x01}), want: 1},
+		{name: "not serving", frame: grpcFrame([]byte{0x08, 0x02}), want: 2},
+		{name: "empty message is unknown", frame: grpcFrame(nil), want: 0},
+		{name: "unknown fields skipped", frame: grpcFrame([]byte{
+			0x12, 0x02, 'h', 'i', // поле 2, строка
+			0x19, 1, 2, 3, 4, 5, 6, 7, 8, // поле 3, fixed64
+			0x25, 1, 2, 3, 4, // поле 4, fixed32
+			0x08, 0x01,
+		}), want: 1},
+		{name: "trailing data after message ignored", frame: append(grpcFrame([]byte{0x08, 0x01}), 0xff), want: 1},
+		{name: "short header", frame: []byte{0, 0, 0}, wantErr: true},
+		{name: "compressed", frame: []byte{1, 0, 0, 0, 0}, wantErr: true},
+		{name: "length beyond frame", frame: []byte{0, 0, 0, 0, 5, 0x08, 0x01}, wantErr: true},
+		{name: "truncated key varint", frame: grpcFrame([]byte{0x88}), wantErr: true},
+		{name: "truncated value varint", frame: grpcFrame([]byte{0x08, 0x81}), wantErr: true},
+		{name: "missing value", frame: grpcFrame([]byte{0x08}), wantErr: true},
+		{name: "overlong varint", frame: grpcFrame([]byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}), wantErr: true},
+		{name: "string length beyond message", frame: grpcFrame([]byte{0x12, 0x05, 'h'}), wantErr: true},
+		{name: "huge string length", frame: grpcFrame([]byte{0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}), wantErr: true},
+		{name: "truncated fixed64", frame: grpcFrame([]byte{0x19, 1, 2}), wantErr: true},
+		{name: "truncated fixed32", frame: grpcFrame([]byte{0x25, 1}), wantErr: true},
+		{name: "group wire type", frame: grpcFrame([]byte{0x0b}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseServingStatus(tt.frame)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidGRPCMessage) {
+					t.Fatalf("err = %v, want %v", err, errInvalidGRPCMessage)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
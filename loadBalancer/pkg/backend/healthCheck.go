@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+// Сколько байт тела ответа читаем при поиске body_contains
+const maxHealthBodySize = 64 * 1024
+
+func checkBackend(b *Backend, hc config.HealthCheckConfig, transport http.RoundTripper) error {
+	if hc.Type == config.HealthCheckTCP || b.URL.Scheme == "tcp" {
+		return checkTCP(b, hc)
+	}
+	if hc.Type == config.HealthCheckGRPC {
+		return checkGRPC(b, hc, transport)
+	}
+
+	client := http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(hc.TimeoutMs) * time.Millisecond,
+		// Редиректы не проходим, код 3xx проверяется по диапазону как есть
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequest(hc.Method, b.URL.JoinPath(hc.Path).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < hc.StatusMin || resp.StatusCode > hc.StatusMax {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if hc.BodyContains != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodySize))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), hc.BodyContains) {
+			return fmt.Errorf("body does not contain %q", hc.BodyContains)
+		}
+	}
+
+	return nil
+}
+
+// Для L4 бэкэндов достаточно, чтобы сервер принимал соединения
+func checkTCP(b *Backend, hc config.HealthCheckConfig) error {
+	conn, err := net.DialTimeout("tcp", b.URL.Host, time.Duration(hc.TimeoutMs)*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Состояние бэкэнда меняется только после rise успешных или fall неуспешных проверок подряд.
+// Первая проверка после запуска применяется сразу, чтобы не слать трафик на заведомо мертвые бэкэнды
+func (b *Backend) recordHealthCheck(ok bool, rise, fall int) {
+	b.Lock()
+	first := !b.healthChecked
+	b.healthChecked = true
+	if ok {
+		b.healthSuccess++
+		b.healthFailures = 0
+	} else {
+		b.healthFailures++
+		b.healthSuccess = 0
+	}
+	wasAlive := b.Alive
+	becomeAlive := ok && !wasAlive && (first || b.healthSuccess >= rise)
+	becomeDead := !ok && wasAlive && (first || b.healthFailures >= fall)
+	b.Unlock()
+
+	switch {
+	case becomeAlive:
+		b.SetAlive(true)
+	case becomeDead:
+		b.SetAlive(false)
+	case first:
+		log.Printf("Backend %s alive=%t breaker=%s", b.URL, wasAlive, b.Breaker.State())
+	}
+}
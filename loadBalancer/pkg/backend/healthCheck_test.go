@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"loadBalancer/pkg/config"
+)
+
+func TestRecordHealthCheckRiseFall(t *testing.T) {
+	const rise, fall = 2, 3
+	tests := []struct {
+		name   string
+		checks []bool
+		// Состояние после каждой проверки
+		want []bool
+	}{
+		{name: "first failure applied at once", checks: []bool{false}, want: []bool{false}},
+		{name: "first success keeps alive", checks: []bool{true}, want: []bool{true}},
+		{
+			name:   "fall failures in a row",
+			checks: []bool{true, false, false, false},
+			want:   []bool{true, true, true, false},
+		},
+		{
+			name:   "success resets failures",
+			checks: []bool{true, false, false, true, false, false},
+			want:   []bool{true, true, true, true, true, true},
+		},
+		{
+			name:   "rise successes to come back",
+			checks: []bool{false, true, true},
+			want:   []bool{false, false, true},
+		},
+		{
+			name:   "failure resets successes",
+			checks: []bool{false, true, false, true, true},
+			want:   []bool{false, false, false, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestPool(t, nil, "http://a.local").Backends[0]
+			for i, ok := range tt.checks {
+				b.recordHealthCheck(ok, rise, fall)
+				if got := b.IsAlive(); got != tt.want[i] {
+					t.Fatalf("check %d: alive = %t, want %t", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBackendHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"status":"ok"}`))
+		case "/redirect":
+			http.Redirect(w, r, "/health", http.StatusFound)
+		default:
+			http.Error(w, "boom", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		hc      config.HealthCheckConfig
+		wantErr bool
+	}{
+		{name: "ok", hc: config.HealthCheckConfig{Path: "/health"}},
+		{name: "5xx", hc: config.HealthCheckConfig{Path: "/down"}, wantErr: true},
+		{name: "status range", hc: config.HealthCheckConfig{Path: "/down", StatusMin: 500, StatusMax: 503}},
+		// Редирект не проходим, проверяется сам код 302
+		{name: "redirect in default range", hc: config.HealthCheckConfig{Path: "/redirect"}},
+		{name: "redirect not followed", hc: config.HealthCheckConfig{Path: "/redirect", StatusMax: 299}, wantErr: true},
+		{name: "body contains", hc: config.HealthCheckConfig{Path: "/health", BodyContains: `"ok"`}},
+		{name: "body missing", hc: config.HealthCheckConfig{Path: "/health", BodyContains: "ready"}, wantErr: true},
+	}
+
+	b := newTestPool(t, nil, srv.URL).Backends[0]
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBackend(b, tt.hc.WithDefaults(), http.DefaultTransport)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckBackendTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	b := newTestPool(t, nil, "tcp://"+addr).Backends[0]
+	hc := config.HealthCheckConfig{}.WithDefaults()
+	if err := checkBackend(b, hc, nil); err != nil {
+		t.Fatalf("listening backend: %v", err)
+	}
+
+	ln.Close()
+	if err := checkBackend(b, hc, nil); err == nil {
+		t.Fatal("closed backend reported healthy")
+	}
+}
+
+func TestPingServersMarksDeadBackend(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	pool := newTestPool(t, nil, up.URL, down.URL)
+	PingServers(pool, &sync.WaitGroup{})
+	if !pool.Backends[0].IsAlive() || pool.Backends[1].IsAlive() {
+		t.Fatalf("alive = %t, %t; want true, false", pool.Backends[0].IsAlive(), pool.Backends[1].IsAlive())
+	}
+}
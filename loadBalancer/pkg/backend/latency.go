@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	latencySamples = 1024
+	// Меньше этого числа замеров перцентиль считается ненадежным
+	minLatencySamples = 20
+)
+
+// Кольцевой буфер последних времен ответа бэкэндов пула, по нему считается перцентиль
+// задержки, например, для задержки перед hedged запросом
+type latencyWindow struct {
+	samples [latencySamples]time.Duration
+	next    int
+	count   int
+	mu      sync.Mutex
+}
+
+func (lw *latencyWindow) observe(d time.Duration) {
+	lw.mu.Lock()
+	lw.samples[lw.next] = d
+	lw.next = (lw.next + 1) % latencySamples
+	if lw.count < latencySamples {
+		lw.count++
+	}
+	lw.mu.Unlock()
+}
+
+func (lw *latencyWindow) percentile(q float64) (time.Duration, bool) {
+	lw.mu.Lock()
+	if lw.count < minLatencySamples {
+		lw.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, lw.count)
+	copy(sorted, lw.samples[:lw.count])
+	lw.mu.Unlock()
+
+	slices.Sort(sorted)
+	idx := int(q / 100 * float64(len(sorted)-1))
+	idx = min(max(idx, 0), len(sorted)-1)
+	return sorted[idx], true
+}
+
+// Учитывает время ответа бэкэнда в окне задержек пула
+func (p *BackendPool) ObserveLatency(d time.Duration) {
+	p.latency.observe(d)
+}
+
+// Перцентиль q (0-100) времени ответа по последним запросам пула. Возвращает false,
+// пока замеров слишком мало
+func (p *BackendPool) LatencyPercentile(q float64) (time.Duration, bool) {
+	return p.latency.percentile(q)
+}
@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"loadBalancer/pkg/metrics"
+)
+
+// Регистрирует gauge состояния бэкэндов всех пулов, значения снимаются при запросе /metrics
+func RegisterPoolMetrics(pools *Pools) {
+	backendGauge := func(name, help string, value func(b *Backend) float64) {
+		metrics.NewGaugeFunc(name, help, []string{"pool", "backend"}, func(set func(float64, ...string)) {
+			for _, pool := range pools.All() {
+				pool.RLock()
+				backends := make([]*Backend, len(pool.Backends))
+				copy(backends, pool.Backends)
+				pool.RUnlock()
+
+				for _, b := range backends {
+					set(value(b), pool.Name, b.URL.String())
+				}
+			}
+		})
+	}
+
+	backendGauge("lb_backend_active_connections", "Active connections to backend", func(b *Backend) float64 {
+		return float64(b.ConnCount())
+	})
+	backendGauge("lb_backend_up", "Backend state by active health check (1 - alive)", func(b *Backend) float64 {
+		return boolToFloat(b.IsAlive())
+	})
+	backendGauge("lb_backend_available", "Backend receives new requests (alive, not drained, ejected or open breaker)", func(b *Backend) float64 {
+		return boolToFloat(b.IsAvailable())
+	})
+	backendGauge("lb_backend_upgraded_connections", "Upgraded (WebSocket) connections to backend", func(b *Backend) float64 {
+		return float64(b.UpgradedCount())
+	})
+	backendGauge("lb_backend_circuit_breaker_state", "Circuit breaker state (0 - closed, 1 - open, 2 - half-open)", func(b *Backend) float64 {
+		return float64(b.Breaker.State())
+	})
+
+	budgetGauge := func(name, help string, value func(s RetryBudgetStatus) float64) {
+		metrics.NewGaugeFunc(name, help, []string{"pool"}, func(set func(float64, ...string)) {
+			for _, pool := range pools.All() {
+				set(value(pool.RetryBudget().Status()), pool.Name)
+			}
+		})
+	}
+
+	budgetGauge("lb_retry_budget_requests", "Requests counted by the retry budget in the current window", func(s RetryBudgetStatus) float64 {
+		return float64(s.Requests)
+	})
+	budgetGauge("lb_retry_budget_retries", "Retries spent from the retry budget in the current window", func(s RetryBudgetStatus) float64 {
+		return float64(s.Retries)
+	})
+	budgetGauge("lb_retry_budget_remaining", "Retries still allowed by the retry budget in the current window", func(s RetryBudgetStatus) float64 {
+		return float64(s.Remaining)
+	})
+}
+
+func boolToFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"log"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+// Окно для доли ошибок делится на корзины фиксированной длины, устаревшие корзины
+// обнуляются при обращении, поэтому отдельная горутина для сдвига окна не нужна
+const outlierBuckets = 10
+
+type outlierBucket struct {
+	slot   int64
+	total  int
+	errors int
+}
+
+type outlierState struct {
+	consecutive  int
+	buckets      [outlierBuckets]outlierBucket
+	ejections    int
+	ejectedUntil time.Time
+}
+
+func (b *Backend) IsEjected() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return time.Now().Before(b.outlier.ejectedUntil)
+}
+
+// Учитывает результат проксированного запроса, возвращает true, если бэкэнд
+// превысил порог ошибок и его нужно исключить
+func (b *Backend) recordResult(ok bool, cfg config.OutlierConfig) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	if now.Before(b.outlier.ejectedUntil) {
+		return false
+	}
+
+	bucketSize := int64(time.Duration(cfg.WindowMs) * time.Millisecond / outlierBuckets)
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	slot := now.UnixNano() / bucketSize
+	bucket := &b.outlier.buckets[slot%outlierBuckets]
+	if bucket.slot != slot {
+		*bucket = outlierBucket{slot: slot}
+	}
+	bucket.total++
+
+	if ok {
+		b.outlier.consecutive = 0
+		return false
+	}
+	bucket.errors++
+	b.outlier.consecutive++
+
+	if b.outlier.consecutive >= cfg.ConsecutiveErrors {
+		return true
+	}
+
+	total, errs := 0, 0
+	for _, bk := range b.outlier.buckets {
+		if slot-bk.slot < outlierBuckets {
+			total += bk.total
+			errs += bk.errors
+		}
+	}
+	return total >= cfg.MinRequests && errs*100 >= total*cfg.ErrorRatePercent
+}
+
+// Исключает бэкэнд на base * 2^(n-1), где n - номер исключения подряд. Если бэкэнд
+// после предыдущего исключения проработал без ошибок дольше max, счетчик сбрасывается
+func (b *Backend) eject(cfg config.OutlierConfig) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	maxEjection := time.Duration(cfg.MaxEjectionMs) * time.Millisecond
+	if now.Sub(b.outlier.ejectedUntil) > maxEjection {
+		b.outlier.ejections = 0
+	}
+	b.outlier.ejections++
+
+	d := time.Duration(cfg.BaseEjectionMs) * time.Millisecond
+	for i := 1; i < b.outlier.ejections && d < maxEjection; i++ {
+		d *= 2
+	}
+	if d > maxEjection {
+		d = maxEjection
+	}
+
+	b.outlier.ejectedUntil = now.Add(d)
+	b.outlier.consecutive = 0
+	b.outlier.buckets = [outlierBuckets]outlierBucket{}
+
+	return d
+}
+
+// Результат проксированного запроса: ошибка транспорта или 5xx считаются неуспехом.
+// Бэкэнд, превысивший порог ошибок, исключается, если не нарушается max_ejection_percent
+func (p *BackendPool) ReportResult(b *Backend, ok bool) {
+	p.RLock()
+	cfg := p.outlierDetection
+	p.RUnlock()
+
+	if !b.recordResult(ok, cfg) {
+		return
+	}
+
+	p.ejectMu.Lock()
+	defer p.ejectMu.Unlock()
+
+	p.RLock()
+	total := len(p.Backends)
+	ejected := 0
+	for _, other := range p.Backends {
+		if other.IsEjected() {
+			ejected++
+		}
+	}
+	p.RUnlock()
+
+	if (ejected+1)*100 > total*cfg.MaxEjectionPercent {
+		log.Printf("Backend %s not ejected: max_ejection_percent reached (%d of %d ejected)", b.URL, ejected, total)
+		return
+	}
+
+	d := b.eject(cfg)
+	log.Printf("Backend %s ejected for %v", b.URL, d)
+}
+
+func (p *BackendPool) SetOutlierDetection(cfg config.OutlierConfig) {
+	p.Lock()
+	p.outlierDetection = cfg.WithDefaults()
+	p.Unlock()
+}
@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+func TestRecordResult(t *testing.T) {
+	cfg := config.OutlierConfig{ConsecutiveErrors: 3, ErrorRatePercent: 50, MinRequests: 6}.WithDefaults()
+	tests := []struct {
+		name    string
+		results []bool
+		want    bool
+	}{
+		{name: "consecutive errors", results: []bool{true, false, false, false}, want: true},
+		{name: "success resets consecutive", results: []bool{false, false, true, false, false}, want: false},
+		{name: "error rate below min requests", results: []bool{true, false, true, false, false}, want: false},
+		{name: "error rate reached", results: []bool{true, false, true, false, true, false}, want: true},
+		{name: "error rate below threshold", results: []bool{true, false, true, true, true, false}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestPool(t, nil, "http://a.local").Backends[0]
+			var got bool
+			for _, ok := range tt.results {
+				got = b.recordResult(ok, cfg)
+			}
+			if got != tt.want {
+				t.Errorf("recordResult = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEjectBackoff(t *testing.T) {
+	cfg := config.OutlierConfig{BaseEjectionMs: 100, MaxEjectionMs: 350}.WithDefaults()
+	b := newTestPool(t, nil, "http://a.local").Backends[0]
+
+	// Каждое следующее исключение подряд вдвое дольше, но не дольше max
+	for _, want := range []time.Duration{100, 200, 350, 350} {
+		if got := b.eject(cfg); got != want*time.Millisecond {
+			t.Fatalf("ejection = %s, want %s", got, want*time.Millisecond)
+		}
+		if !b.IsEjected() {
+			t.Fatal("backend not ejected")
+		}
+		b.Lock()
+		b.outlier.ejectedUntil = time.Now()
+		b.Unlock()
+	}
+
+	// Бэкэнд проработал без ошибок дольше max, счетчик сбрасывается
+	b.Lock()
+	b.outlier.ejectedUntil = time.Now().Add(-time.Second)
+	b.Unlock()
+	if got := b.eject(cfg); got != 100*time.Millisecond {
+		t.Errorf("ejection after recovery = %s, want 100ms", got)
+	}
+}
+
+func TestReportResultEjects(t *testing.T) {
+	pool := newTestPool(t, &RoundRobinStrategy{}, "http://a.local", "http://b.local")
+	pool.SetOutlierDetection(config.OutlierConfig{ConsecutiveErrors: 2})
+	a, b := pool.Backends[0], pool.Backends[1]
+
+	pool.ReportResult(a, false)
+	pool.ReportResult(a, false)
+	if !a.IsEjected() || a.IsAvailable() {
+		t.Fatal("backend a not ejected")
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < 4; i++ {
+		if got := pool.NextBackend(req); got != b {
+			t.Fatalf("picked %s, want ejected backend skipped", got.URL)
+		}
+	}
+
+	// Результаты исключенного бэкэнда не учитываются
+	if a.recordResult(false, pool.outlierDetection) {
+		t.Error("ejected backend counted errors")
+	}
+
+	// Второй бэкэнд не исключается: max_ejection_percent 50 из двух уже занят
+	pool.ReportResult(b, false)
+	pool.ReportResult(b, false)
+	if b.IsEjected() {
+		t.Error("max_ejection_percent exceeded")
+	}
+}
@@ -0,0 +1,57 @@
+package backend
+
+import (
+	"math/rand"
+	"net/http"
+)
+
+// Power of two choices: берем два случайных живых бэкэнда и выбираем менее
+// загруженный из них. В отличие от LeastConnectionsStrategy не нужно сортировать
+// весь пул на каждый запрос, и при этом нет стадного эффекта на один бэкэнд
+type P2CStrategy struct{}
+
+func (p *P2CStrategy) NextBackend(pool *BackendPool, req *http.Request) *Backend {
+	a, b := pickTwo(pool.getAliveBackends(req))
+	if a == nil || b == nil {
+		return a
+	}
+	if b.ConnCount() < a.ConnCount() {
+		return b
+	}
+	return a
+}
+
+// Та же выборка из двух, но сравнивается стоимость бэкэнда: peak EWMA времени ответа,
+// умноженное на число активных соединений. Медленные, но живые бэкэнды получают меньше
+// трафика. Бэкэнды без замеров имеют нулевую стоимость и поэтому быстро получают запросы
+type PeakEWMAStrategy struct{}
+
+func (p *PeakEWMAStrategy) NextBackend(pool *BackendPool, req *http.Request) *Backend {
+	a, b := pickTwo(pool.getAliveBackends(req))
+	if a == nil || b == nil {
+		return a
+	}
+	if ewmaCost(b) < ewmaCost(a) {
+		return b
+	}
+	return a
+}
+
+func ewmaCost(b *Backend) float64 {
+	return float64(b.Latency()) * float64(b.ConnCount()+1)
+}
+
+func pickTwo(alive []*Backend) (*Backend, *Backend) {
+	switch len(alive) {
+	case 0:
+		return nil, nil
+	case 1:
+		return alive[0], nil
+	}
+	i := rand.Intn(len(alive))
+	j := rand.Intn(len(alive) - 1)
+	if j >= i {
+		j++
+	}
+	return alive[i], alive[j]
+}
@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+func newTestPool(t *testing.T, strategy BalancerStrategy, urls ...string) *BackendPool {
+	t.Helper()
+	var backends []config.BackendConfig
+	for _, u := range urls {
+		backends = append(backends, config.BackendConfig{URL: u, Weight: 1})
+	}
+	pool := NewBackendPool(backends)
+	pool.Strategy = strategy
+	return pool
+}
+
+func TestP2CPicksLessLoaded(t *testing.T) {
+	tests := []struct {
+		name  string
+		conns []int64
+		// Индексы бэкэндов, которые могут быть выбраны
+		want []int
+	}{
+		{name: "single backend", conns: []int64{7}, want: []int{0}},
+		{name: "two backends", conns: []int64{5, 1}, want: []int{1}},
+		{name: "tie", conns: []int64{2, 2}, want: []int{0, 1}},
+		// Самый загруженный из трех никогда не выигрывает сравнение двух разных бэкэндов
+		{name: "busiest never picked", conns: []int64{1, 9, 3}, want: []int{0, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls := []string{"http://a.local", "http://b.local", "http://c.local"}[:len(tt.conns)]
+			pool := newTestPool(t, &P2CStrategy{}, urls...)
+			for i, n := range tt.conns {
+				pool.Backends[i].ActiveConn = n
+			}
+
+			allowed := map[*Backend]bool{}
+			for _, i := range tt.want {
+				allowed[pool.Backends[i]] = true
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			for i := 0; i < 200; i++ {
+				if b := pool.NextBackend(req); !allowed[b] {
+					t.Fatalf("picked %s", b.URL)
+				}
+			}
+		})
+	}
+}
+
+func TestPeakEWMAPicksCheapest(t *testing.T) {
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		conns     []int64
+		want      int
+	}{
+		{name: "faster backend", latencies: []time.Duration{100 * time.Millisecond, 10 * time.Millisecond}, want: 1},
+		// 10ms * 21 соединение дороже, чем 100ms * 1
+		{name: "fast but overloaded", latencies: []time.Duration{100 * time.Millisecond, 10 * time.Millisecond}, conns: []int64{0, 20}, want: 0},
+		{name: "no samples yet", latencies: []time.Duration{10 * time.Millisecond, 0}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, &PeakEWMAStrategy{}, "http://a.local", "http://b.local")
+			for i, d := range tt.latencies {
+				if d > 0 {
+					pool.Backends[i].ObserveLatency(d)
+				}
+			}
+			for i, n := range tt.conns {
+				pool.Backends[i].ActiveConn = n
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for i := 0; i < 50; i++ {
+				if b := pool.NextBackend(req); b != pool.Backends[tt.want] {
+					t.Fatalf("picked %s, want %s", b.URL, pool.Backends[tt.want].URL)
+				}
+			}
+		})
+	}
+}
+
+func TestObserveLatencyPeakAndDecay(t *testing.T) {
+	b := newTestPool(t, nil, "http://a.local").Backends[0]
+
+	b.ObserveLatency(10 * time.Millisecond)
+	b.ObserveLatency(100 * time.Millisecond)
+	if got := b.Latency(); got != 100*time.Millisecond {
+		t.Fatalf("peak latency = %s, want 100ms", got)
+	}
+
+	// Сразу после пика быстрый ответ почти не снижает оценку
+	b.ObserveLatency(10 * time.Millisecond)
+	if got := b.Latency(); got < 90*time.Millisecond {
+		t.Errorf("latency right after peak = %s, want close to 100ms", got)
+	}
+
+	// Спустя много периодов затухания оценка почти равна новому замеру
+	b.Lock()
+	b.latencyStamp = time.Now().Add(-20 * latencyDecay)
+	b.Unlock()
+	b.ObserveLatency(10 * time.Millisecond)
+	if got := b.Latency(); got > 11*time.Millisecond {
+		t.Errorf("latency after decay = %s, want close to 10ms", got)
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	lw := &latencyWindow{}
+	for i := 1; i < minLatencySamples; i++ {
+		lw.observe(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := lw.percentile(50); ok {
+		t.Fatal("percentile reported with too few samples")
+	}
+
+	lw = &latencyWindow{}
+	for i := 100; i >= 1; i-- {
+		lw.observe(time.Duration(i) * time.Millisecond)
+	}
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{q: 0, want: time.Millisecond},
+		{q: 50, want: 50 * time.Millisecond},
+		{q: 99, want: 99 * time.Millisecond},
+		{q: 100, want: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got, ok := lw.percentile(tt.q); !ok || got != tt.want {
+			t.Errorf("p%v = %s, %t; want %s", tt.q, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+// Именованные пулы бэкэндов, на которые маршрутизируются запросы. Набор пулов
+// задается при старте, при перечитывании конфига меняются только их настройки, а конфиг
+// с добавленными или удаленными пулами отклоняется
+type Pools struct {
+	pools map[string]*BackendPool
+	names []string
+}
+
+func NewPools(cfg *config.Config) (*Pools, error) {
+	configs := cfg.PoolConfigs()
+	ps := &Pools{pools: make(map[string]*BackendPool, len(configs))}
+
+	for name, pc := range configs {
+		pool := NewBackendPool(pc.Backends)
+		pool.Name = name
+		if err := pool.SetAlgorithm(pc.Algorithm, *pc.Hash); err != nil {
+			return nil, fmt.Errorf("pool %s: %w", name, err)
+		}
+		pool.configAlgorithm, pool.configHash = pc.Algorithm, *pc.Hash
+		pool.SetHealthCheck(*pc.HealthCheck)
+		pool.SetOutlierDetection(*pc.OutlierDetection)
+		pool.SetCircuitBreaker(*pc.CircuitBreaker)
+		pool.SetRetryBudget(*pc.RetryBudget)
+		if err := pool.SetUpstream(*pc.UpstreamTLS, pc.Protocol); err != nil {
+			return nil, fmt.Errorf("pool %s: %w", name, err)
+		}
+
+		ps.pools[name] = pool
+		ps.names = append(ps.names, name)
+	}
+	sort.Strings(ps.names)
+
+	return ps, nil
+}
+
+// Возвращает nil, если пула с таким именем нет
+func (ps *Pools) Get(name string) *BackendPool {
+	return ps.pools[name]
+}
+
+// Все пулы в порядке имен
+func (ps *Pools) All() []*BackendPool {
+	result := make([]*BackendPool, 0, len(ps.names))
+	for _, name := range ps.names {
+		result = append(result, ps.pools[name])
+	}
+	return result
+}
+
+// Запускает активные проверки всех пулов, каждый со своим интервалом, и ждет их остановки
+func (ps *Pools) HealthCheck(ctx context.Context, cfg *config.Config) {
+	configs := cfg.PoolConfigs()
+
+	wg := &sync.WaitGroup{}
+	for name, pool := range ps.pools {
+		wg.Add(1)
+		go func(pool *BackendPool, interval time.Duration) {
+			defer wg.Done()
+			pool.HealthCheck(ctx, interval)
+		}(pool, time.Duration(configs[name].HealthCheckInterval)*time.Second)
+	}
+	wg.Wait()
+}
+
+// Применяет перечитанный конфиг ко всем пулам. Набор пулов и алгоритмы проверяются заранее,
+// чтобы ошибка в одном пуле не оставила остальные пулы с уже примененным конфигом
+func (ps *Pools) ApplyConfig(cfg *config.Config) error {
+	configs := cfg.PoolConfigs()
+	for _, name := range ps.names {
+		if _, ok := configs[name]; !ok {
+			return fmt.Errorf("%w: pool %s removed", ErrPoolsChanged, name)
+		}
+	}
+	for name := range configs {
+		if _, ok := ps.pools[name]; !ok {
+			return fmt.Errorf("%w: pool %s added", ErrPoolsChanged, name)
+		}
+	}
+	for name, pc := range configs {
+		if _, err := NewStrategy(pc.Algorithm, *pc.Hash); err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+
+	for _, name := range ps.names {
+		if err := ps.pools[name].ApplyConfig(configs[name]); err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Закрывает upgraded соединения всех пулов
+func (ps *Pools) CloseUpgraded() {
+	for _, pool := range ps.All() {
+		pool.CloseUpgraded()
+	}
+}
@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+// Применяет перечитанный конфиг к работающему пулу. Бэкэнды, которые остались в конфиге,
+// сохраняются вместе с соединениями и счетчиками, новые добавляются после активной проверки,
+// пропавшие удаляются. Применяется только разница со старым конфигом: бэкэнды, добавленные
+// через admin API, остаются, удаленные через admin API не возвращаются, а алгоритм, смененный
+// через admin API, меняется, только если он изменен в конфиге. Если конфиг не удается
+// применить, пул не меняется
+func (p *BackendPool) ApplyConfig(cfg config.PoolConfig) error {
+	p.RLock()
+	algorithmChanged := cfg.Algorithm != p.configAlgorithm || *cfg.Hash != p.configHash
+	p.RUnlock()
+
+	var strategy BalancerStrategy
+	if algorithmChanged {
+		var err error
+		if strategy, err = NewStrategy(cfg.Algorithm, *cfg.Hash); err != nil {
+			return err
+		}
+	}
+
+	if err := p.SetUpstream(*cfg.UpstreamTLS, cfg.Protocol); err != nil {
+		return err
+	}
+	if err := p.syncBackends(cfg.Backends, cfg.HealthCheck.WithDefaults()); err != nil {
+		return err
+	}
+
+	p.SetHealthCheck(*cfg.HealthCheck)
+	p.SetOutlierDetection(*cfg.OutlierDetection)
+	p.SetCircuitBreaker(*cfg.CircuitBreaker)
+	p.SetRetryBudget(*cfg.RetryBudget)
+
+	if algorithmChanged {
+		p.Lock()
+		p.Strategy = strategy
+		p.Algorithm = cfg.Algorithm
+		p.hash = *cfg.Hash
+		p.configAlgorithm = cfg.Algorithm
+		p.configHash = *cfg.Hash
+		p.Unlock()
+		log.Printf("Pool %s: balancing algorithm set to %s", p.Name, cfg.Algorithm)
+	}
+
+	p.SetHealthCheckInterval(time.Duration(cfg.HealthCheckInterval) * time.Second)
+	return nil
+}
+
+func (p *BackendPool) syncBackends(backends []config.BackendConfig, hc config.HealthCheckConfig) error {
+	p.membershipMu.Lock()
+	defer p.membershipMu.Unlock()
+
+	transport := p.Transport()
+
+	p.RLock()
+	current := make([]*Backend, len(p.Backends))
+	copy(current, p.Backends)
+	p.RUnlock()
+	existing := make(map[string]*Backend, len(current))
+	for _, b := range current {
+		existing[b.URL.String()] = b
+	}
+
+	var added []*Backend
+	updated := make([]*Backend, 0, len(backends))
+	weights := make(map[*Backend]int)
+	configured := make(map[string]struct{}, len(backends))
+	for _, bc := range backends {
+		b, err := p.newBackend(bc)
+		if err != nil {
+			return err
+		}
+		rawURL := b.URL.String()
+		configured[rawURL] = struct{}{}
+		if old, ok := existing[rawURL]; ok {
+			updated = append(updated, old)
+			weights[old] = b.Weight
+			continue
+		}
+		if _, ok := p.configBackends[rawURL]; ok {
+			// Был в старом конфиге, но удален через admin API
+			log.Printf("Backend %s was removed through admin API, not adding it back", rawURL)
+			continue
+		}
+		added = append(added, b)
+		updated = append(updated, b)
+	}
+	// Бэкэнды, которых не было в старом конфиге, добавлены через admin API и остаются
+	for _, b := range current {
+		rawURL := b.URL.String()
+		_, inConfig := configured[rawURL]
+		_, wasInConfig := p.configBackends[rawURL]
+		if !inConfig && !wasInConfig {
+			updated = append(updated, b)
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, b := range added {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			b.recordHealthCheck(checkBackend(b, hc, transport) == nil, hc.Rise, hc.Fall)
+		}(b)
+	}
+	wg.Wait()
+
+	for b, weight := range weights {
+		b.SetWeight(weight)
+	}
+
+	p.Lock()
+	removed := make(map[string]*Backend, len(existing))
+	for _, b := range p.Backends {
+		removed[b.URL.String()] = b
+	}
+	p.Backends = updated
+	p.Unlock()
+	p.configBackends = configured
+
+	for _, b := range updated {
+		delete(removed, b.URL.String())
+	}
+	for _, b := range added {
+		log.Printf("Backend %s added", b.URL)
+	}
+	for rawURL, b := range removed {
+		b.closeLongLived()
+		log.Printf("Backend %s removed", rawURL)
+	}
+	return nil
+}
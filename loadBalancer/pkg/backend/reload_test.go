@@ -0,0 +1,171 @@
+package backend
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"loadBalancer/pkg/config"
+)
+
+func reloadConfig(algorithm string, urls ...string) *config.Config {
+	cfg := &config.Config{Algorithm: algorithm, HealthCheckInterval: 5}
+	for _, u := range urls {
+		cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: u, Weight: 1})
+	}
+	return cfg
+}
+
+func poolURLs(pool *BackendPool) []string {
+	pool.RLock()
+	defer pool.RUnlock()
+	var urls []string
+	for _, b := range pool.Backends {
+		urls = append(urls, b.URL.String())
+	}
+	return urls
+}
+
+func TestApplyConfigBackends(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	a, b, c, d := srv.URL+"/a", srv.URL+"/b", srv.URL+"/c", srv.URL+"/d"
+
+	tests := []struct {
+		name string
+		// Изменения через admin API между запуском и перечитыванием
+		admin func(t *testing.T, pool *BackendPool)
+		file  []string
+		want  []string
+	}{
+		{name: "file adds and removes", file: []string{a, c}, want: []string{a, c}},
+		{
+			name: "admin added backend kept",
+			admin: func(t *testing.T, pool *BackendPool) {
+				if _, err := pool.AddBackend(config.BackendConfig{URL: d}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			file: []string{a, b, c},
+			want: []string{a, b, c, d},
+		},
+		{
+			name: "admin removed backend not added back",
+			admin: func(t *testing.T, pool *BackendPool) {
+				if err := pool.RemoveBackend(b); err != nil {
+					t.Fatal(err)
+				}
+			},
+			file: []string{a, b, c},
+			want: []string{a, c},
+		},
+		{
+			// Бэкэнд, добавленный через admin API и затем появившийся в конфиге, удаляется вместе с ним
+			name: "admin added backend adopted by file",
+			admin: func(t *testing.T, pool *BackendPool) {
+				if _, err := pool.AddBackend(config.BackendConfig{URL: d}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			file: []string{a, b, d},
+			want: []string{a, b, d},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pools, err := NewPools(reloadConfig(RoundRobinAlg, a, b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool := pools.Get(config.DefaultPool)
+			kept := pool.Backends[0]
+			kept.IncConn()
+			if tt.admin != nil {
+				tt.admin(t, pool)
+			}
+
+			if err := pools.ApplyConfig(reloadConfig(RoundRobinAlg, tt.file...)); err != nil {
+				t.Fatal(err)
+			}
+			if got := poolURLs(pool); !slices.Equal(got, tt.want) {
+				t.Fatalf("backends = %v, want %v", got, tt.want)
+			}
+			// Оставшийся бэкэнд тот же самый, вместе со счетчиками
+			if pool.FindBackend(a) != kept || kept.ConnCount() != 1 {
+				t.Error("existing backend replaced on reload")
+			}
+		})
+	}
+
+	// После того как бэкэнд d попал в конфиг, удаление из файла убирает его из пула
+	pools, err := NewPools(reloadConfig(RoundRobinAlg, a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range [][]string{{a, d}, {a}} {
+		if err := pools.ApplyConfig(reloadConfig(RoundRobinAlg, file...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := poolURLs(pools.Get(config.DefaultPool)); !slices.Equal(got, []string{a}) {
+		t.Errorf("backends = %v, want [%s]", got, a)
+	}
+}
+
+func TestApplyConfigAlgorithm(t *testing.T) {
+	tests := []struct {
+		name  string
+		admin string
+		file  string
+		want  string
+	}{
+		{name: "file change applied", file: LeastConnAlg, want: LeastConnAlg},
+		{name: "admin change kept", admin: RandomAlg, file: RoundRobinAlg, want: RandomAlg},
+		{name: "file change wins over admin", admin: RandomAlg, file: LeastConnAlg, want: LeastConnAlg},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pools, err := NewPools(reloadConfig(RoundRobinAlg, "http://127.0.0.1:1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool := pools.Get(config.DefaultPool)
+			if tt.admin != "" {
+				if err := pool.SetAlgorithm(tt.admin, config.HashConfig{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := pools.ApplyConfig(reloadConfig(tt.file, "http://127.0.0.1:1")); err != nil {
+				t.Fatal(err)
+			}
+			if got := pool.GetAlgorithm(); got != tt.want {
+				t.Errorf("algorithm = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigRejected(t *testing.T) {
+	pools, err := NewPools(reloadConfig(RoundRobinAlg, "http://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := reloadConfig(RoundRobinAlg, "http://127.0.0.1:1")
+	cfg.Pools = map[string]config.PoolConfig{"api": {Backends: cfg.Backends}}
+	if err := pools.ApplyConfig(cfg); !errors.Is(err, ErrPoolsChanged) {
+		t.Errorf("added pool: err = %v, want %v", err, ErrPoolsChanged)
+	}
+
+	if err := pools.ApplyConfig(reloadConfig("unknown", "http://127.0.0.1:2")); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("unknown algorithm: err = %v, want %v", err, ErrUnknownAlgorithm)
+	}
+	if got := poolURLs(pools.Get(config.DefaultPool)); !slices.Equal(got, []string{"http://127.0.0.1:1"}) {
+		t.Errorf("pool changed by rejected config: %v", got)
+	}
+}
@@ -0,0 +1,138 @@
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+// Окно бюджета делится на корзины так же, как окно outlier detection
+const retryBudgetBuckets = 10
+
+type retryBudgetBucket struct {
+	slot     int64
+	requests int
+	retries  int
+}
+
+// Бюджет повторов на весь пул. Когда болеет несколько бэкэндов, каждый запрос может
+// повторяться до max_retries раз и умножать нагрузку на оставшиеся. Бюджет ограничивает
+// долю повторов от входящих запросов за окно, а сверх бюджета повторы не выполняются
+type RetryBudget struct {
+	percent          int
+	minRetriesPerSec int
+	windowMs         int
+	buckets          [retryBudgetBuckets]retryBudgetBucket
+	exhausted        uint64
+	mu               sync.Mutex
+}
+
+type RetryBudgetStatus struct {
+	Percent          int    `json:"percent"`
+	MinRetriesPerSec int    `json:"min_retries_per_sec"`
+	WindowMs         int    `json:"window_ms"`
+	Requests         int    `json:"requests"`
+	Retries          int    `json:"retries"`
+	Remaining        int    `json:"remaining"`
+	Exhausted        uint64 `json:"exhausted"`
+}
+
+func NewRetryBudget(cfg config.RetryBudgetConfig) *RetryBudget {
+	rb := &RetryBudget{}
+	rb.Configure(cfg)
+	return rb
+}
+
+func (rb *RetryBudget) Configure(cfg config.RetryBudgetConfig) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	cfg = cfg.WithDefaults()
+	if cfg.WindowMs != rb.windowMs {
+		rb.buckets = [retryBudgetBuckets]retryBudgetBucket{}
+	}
+	rb.percent = *cfg.Percent
+	rb.minRetriesPerSec = *cfg.MinRetriesPerSec
+	rb.windowMs = cfg.WindowMs
+}
+
+// Учитывает входящий запрос, вызывается один раз на запрос клиента
+func (rb *RetryBudget) RecordRequest() {
+	rb.mu.Lock()
+	rb.bucket(time.Now()).requests++
+	rb.mu.Unlock()
+}
+
+// Занимает место в бюджете под повтор. Возвращает false, если бюджет исчерпан
+func (rb *RetryBudget) AllowRetry() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	now := time.Now()
+	if rb.remaining(now) <= 0 {
+		rb.exhausted++
+		return false
+	}
+	rb.bucket(now).retries++
+	return true
+}
+
+func (rb *RetryBudget) Status() RetryBudgetStatus {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	now := time.Now()
+	requests, retries := rb.totals(now)
+	return RetryBudgetStatus{
+		Percent:          rb.percent,
+		MinRetriesPerSec: rb.minRetriesPerSec,
+		WindowMs:         rb.windowMs,
+		Requests:         requests,
+		Retries:          retries,
+		Remaining:        max(rb.remaining(now), 0),
+		Exhausted:        rb.exhausted,
+	}
+}
+
+func (rb *RetryBudget) slot(now time.Time) int64 {
+	bucketSize := int64(time.Duration(rb.windowMs) * time.Millisecond / retryBudgetBuckets)
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	return now.UnixNano() / bucketSize
+}
+
+func (rb *RetryBudget) bucket(now time.Time) *retryBudgetBucket {
+	slot := rb.slot(now)
+	bucket := &rb.buckets[slot%retryBudgetBuckets]
+	if bucket.slot != slot {
+		*bucket = retryBudgetBucket{slot: slot}
+	}
+	return bucket
+}
+
+func (rb *RetryBudget) totals(now time.Time) (requests, retries int) {
+	slot := rb.slot(now)
+	for _, bk := range rb.buckets {
+		if slot-bk.slot < retryBudgetBuckets {
+			requests += bk.requests
+			retries += bk.retries
+		}
+	}
+	return requests, retries
+}
+
+func (rb *RetryBudget) remaining(now time.Time) int {
+	requests, retries := rb.totals(now)
+	allowed := requests*rb.percent/100 + rb.minRetriesPerSec*rb.windowMs/1000
+	return allowed - retries
+}
+
+func (p *BackendPool) RetryBudget() *RetryBudget {
+	return p.retryBudget
+}
+
+func (p *BackendPool) SetRetryBudget(cfg config.RetryBudgetConfig) {
+	p.retryBudget.Configure(cfg)
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"testing"
+
+	"loadBalancer/pkg/config"
+)
+
+func TestRetryBudget(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	tests := []struct {
+		name     string
+		cfg      config.RetryBudgetConfig
+		requests int
+		want     int
+	}{
+		{name: "percent only", cfg: config.RetryBudgetConfig{Percent: intPtr(20), MinRetriesPerSec: intPtr(0), WindowMs: 10000}, requests: 100, want: 20},
+		{name: "min retries only", cfg: config.RetryBudgetConfig{Percent: intPtr(0), MinRetriesPerSec: intPtr(5), WindowMs: 2000}, requests: 100, want: 10},
+		{name: "percent and min retries", cfg: config.RetryBudgetConfig{Percent: intPtr(10), MinRetriesPerSec: intPtr(1), WindowMs: 1000}, requests: 50, want: 6},
+		{name: "rounds down", cfg: config.RetryBudgetConfig{Percent: intPtr(10), MinRetriesPerSec: intPtr(0), WindowMs: 10000}, requests: 19, want: 1},
+		{name: "zero budget", cfg: config.RetryBudgetConfig{Percent: intPtr(0), MinRetriesPerSec: intPtr(0), WindowMs: 10000}, requests: 100, want: 0},
+		// 20% от 100 и 3 в секунду за 10 секунд
+		{name: "defaults", requests: 100, want: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := NewRetryBudget(tt.cfg)
+			for i := 0; i < tt.requests; i++ {
+				rb.RecordRequest()
+			}
+			if got := rb.Status().Remaining; got != tt.want {
+				t.Errorf("remaining = %d, want %d", got, tt.want)
+			}
+
+			allowed := 0
+			for rb.AllowRetry() {
+				allowed++
+				if allowed > tt.want {
+					break
+				}
+			}
+			if allowed != tt.want {
+				t.Errorf("allowed %d retries, want %d", allowed, tt.want)
+			}
+
+			status := rb.Status()
+			if status.Remaining != 0 || status.Retries != tt.want || status.Exhausted != 1 {
+				t.Errorf("status = %+v, want remaining 0, retries %d, exhausted 1", status, tt.want)
+			}
+		})
+	}
+}
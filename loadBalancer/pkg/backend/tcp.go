@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"io"
+	"log"
+)
+
+// Регистрирует L4 соединение (TCP listener) бэкэнда. Такие соединения могут жить сколько
+// угодно, поэтому, как и upgraded, закрываются при drain или удалении бэкэнда
+func (b *Backend) TrackTCP(c io.Closer) {
+	b.Lock()
+	if b.tcpConns == nil {
+		b.tcpConns = make(map[io.Closer]struct{})
+	}
+	b.tcpConns[c] = struct{}{}
+	b.Unlock()
+}
+
+func (b *Backend) UntrackTCP(c io.Closer) {
+	b.Lock()
+	delete(b.tcpConns, c)
+	b.Unlock()
+}
+
+// Закрывает все L4 соединения бэкэнда
+func (b *Backend) CloseTCP() {
+	b.RLock()
+	conns := make([]io.Closer, 0, len(b.tcpConns))
+	for c := range b.tcpConns {
+		conns = append(conns, c)
+	}
+	b.RUnlock()
+
+	if len(conns) == 0 {
+		return
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	log.Printf("Backend %s: closed %d TCP connections", b.URL, len(conns))
+}
+
+// Закрывает соединения, которые не завершатся сами: upgraded и L4
+func (b *Backend) closeLongLived() {
+	b.CloseUpgraded()
+	b.CloseTCP()
+}
@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"log"
+	"net/http"
+
+	"loadBalancer/pkg/certs"
+	"loadBalancer/pkg/config"
+)
+
+// Транспорт до бэкэндов пула. Без настроек upstream_tls и protocol используется http.DefaultTransport
+func (p *BackendPool) Transport() http.RoundTripper {
+	p.RLock()
+	defer p.RUnlock()
+	return p.transport
+}
+
+func (p *BackendPool) Protocol() string {
+	p.RLock()
+	defer p.RUnlock()
+	return p.protocol
+}
+
+// Пересоздает транспорт пула, если изменились настройки TLS до бэкэндов, протокол или
+// файлы CA и клиентского сертификата на диске. Соединения старого транспорта закрываются
+// по мере завершения запросов
+func (p *BackendPool) SetUpstream(cfg config.UpstreamTLSConfig, protocol string) error {
+	if protocol == "" {
+		protocol = config.ProtocolHTTP
+	}
+	files := certs.UpstreamFilesState(cfg)
+
+	p.RLock()
+	unchanged := cfg == p.upstreamTLS && protocol == p.protocol && files == p.upstreamFiles
+	p.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	tlsCfg, err := certs.UpstreamTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+
+	// По HTTP/2 запросы к бэкэнду идут потоками одного соединения, но бэкэнд все равно
+	// выбирается на каждый запрос, поэтому нагрузка gRPC вызовов распределяется по запросам
+	switch protocol {
+	case config.ProtocolH2:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+	case config.ProtocolH2C:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	if cfg.InsecureSkipVerify {
+		log.Printf("Pool %s: upstream TLS certificate verification is DISABLED (insecure_skip_verify), use only for testing", p.Name)
+	}
+
+	p.Lock()
+	old := p.transport
+	p.transport = transport
+	p.upstreamTLS = cfg
+	p.upstreamFiles = files
+	p.protocol = protocol
+	p.Unlock()
+
+	if old != http.DefaultTransport {
+		if t, ok := old.(*http.Transport); ok {
+			t.CloseIdleConnections()
+		}
+	}
+	return nil
+}
+
+// Перечитывает CA и клиентский сертификат пула, если файлы изменились (например, после
+// ротации сертификатов). Пока новые файлы не сходятся, остается старый транспорт
+func (p *BackendPool) reloadUpstreamFiles() {
+	p.RLock()
+	cfg, protocol, last := p.upstreamTLS, p.protocol, p.upstreamFiles
+	p.RUnlock()
+
+	if certs.UpstreamFilesState(cfg) == last {
+		return
+	}
+	if err := p.SetUpstream(cfg, protocol); err != nil {
+		log.Printf("Pool %s: upstream TLS files not reloaded: %v", p.Name, err)
+		return
+	}
+	log.Printf("Pool %s: upstream TLS files reloaded", p.Name)
+}
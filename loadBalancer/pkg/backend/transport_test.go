@@ -0,0 +1,195 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+func writePEMFile(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Самоподписанный клиентский сертификат, записанный в dir. Сертификат сам служит CA для
+// проверки на стороне сервера
+func newClientCert(t *testing.T, dir string) (cert *x509.Certificate, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "lb"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	writePEMFile(t, certFile, "CERTIFICATE", der)
+	writePEMFile(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return cert, certFile, keyFile
+}
+
+func upstreamGet(pool *BackendPool, rawURL string) error {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := pool.Transport().RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestSetUpstreamTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, clientCertFile, clientKeyFile := newClientCert(t, dir)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEMFile(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+
+	mtls := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mtls.StartTLS()
+	defer mtls.Close()
+
+	tests := []struct {
+		name    string
+		cfg     config.UpstreamTLSConfig
+		url     string
+		wantErr bool
+	}{
+		{name: "system roots", url: srv.URL, wantErr: true},
+		{name: "ca file", cfg: config.UpstreamTLSConfig{CAFile: caFile}, url: srv.URL},
+		{name: "insecure skip verify", cfg: config.UpstreamTLSConfig{InsecureSkipVerify: true}, url: srv.URL},
+		// Сертификат httptest выпущен на example.com и 127.0.0.1
+		{name: "server name", cfg: config.UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"}, url: srv.URL},
+		{name: "server name mismatch", cfg: config.UpstreamTLSConfig{CAFile: caFile, ServerName: "other.local"}, url: srv.URL, wantErr: true},
+		{name: "mtls without client cert", cfg: config.UpstreamTLSConfig{CAFile: caFile}, url: mtls.URL, wantErr: true},
+		{name: "mtls", cfg: config.UpstreamTLSConfig{CAFile: caFile, CertFile: clientCertFile, KeyFile: clientKeyFile}, url: mtls.URL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, nil, tt.url)
+			if err := pool.SetUpstream(tt.cfg, ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := upstreamGet(pool, tt.url); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetUpstreamErrors(t *testing.T) {
+	dir := t.TempDir()
+	notCA := filepath.Join(dir, "not-ca.pem")
+	if err := os.WriteFile(notCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, certFile, _ := newClientCert(t, dir)
+
+	tests := []struct {
+		name string
+		cfg  config.UpstreamTLSConfig
+	}{
+		{name: "missing ca", cfg: config.UpstreamTLSConfig{CAFile: filepath.Join(dir, "missing.pem")}},
+		{name: "invalid ca", cfg: config.UpstreamTLSConfig{CAFile: notCA}},
+		{name: "key mismatch", cfg: config.UpstreamTLSConfig{CertFile: certFile, KeyFile: notCA}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, nil, "https://127.0.0.1:1")
+			before := pool.Transport()
+			if err := pool.SetUpstream(tt.cfg, ""); err == nil {
+				t.Fatal("invalid upstream tls accepted")
+			}
+			if pool.Transport() != before {
+				t.Error("transport replaced after error")
+			}
+		})
+	}
+}
+
+func TestReloadUpstreamFiles(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// Сначала в файле CA другой сертификат, и бэкэнд не проходит проверку
+	dir := t.TempDir()
+	other, _, _ := newClientCert(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEMFile(t, caFile, "CERTIFICATE", other.Raw)
+
+	pool := newTestPool(t, nil, srv.URL)
+	cfg := config.UpstreamTLSConfig{CAFile: caFile}
+	if err := pool.SetUpstream(cfg, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := upstreamGet(pool, srv.URL); err == nil {
+		t.Fatal("backend verified with wrong ca")
+	}
+
+	// Без изменений на диске транспорт не пересоздается
+	before := pool.Transport()
+	pool.reloadUpstreamFiles()
+	if err := pool.SetUpstream(cfg, ""); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Transport() != before {
+		t.Fatal("transport recreated without changes")
+	}
+
+	// Невалидный файл не применяется, остается старый транспорт
+	if err := os.WriteFile(caFile, []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool.reloadUpstreamFiles()
+	if pool.Transport() != before {
+		t.Fatal("transport replaced with invalid ca")
+	}
+
+	writePEMFile(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+	pool.reloadUpstreamFiles()
+	if err := upstreamGet(pool, srv.URL); err != nil {
+		t.Errorf("after reload: %v", err)
+	}
+}
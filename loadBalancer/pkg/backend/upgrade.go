@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"io"
+	"log"
+
+	"loadBalancer/pkg/metrics"
+)
+
+// Соединения после HTTP Upgrade (WebSocket и т.п.) живут дольше запроса, поэтому учитываются
+// отдельно: место под соединение резервируется до отправки запроса, чтобы не превысить лимит,
+// а само соединение регистрируется, чтобы закрыть его при drain или удалении бэкэнда
+type upgradeState struct {
+	reserved int
+	conns    map[io.Closer]struct{}
+}
+
+// Резервирует место под upgraded соединение, max <= 0 - без ограничения
+func (b *Backend) AcquireUpgrade(max int) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if max > 0 && b.upgrade.reserved >= max {
+		return false
+	}
+	b.upgrade.reserved++
+	return true
+}
+
+func (b *Backend) ReleaseUpgrade() {
+	b.Lock()
+	if b.upgrade.reserved > 0 {
+		b.upgrade.reserved--
+	}
+	b.Unlock()
+}
+
+func (b *Backend) UpgradedCount() int {
+	b.RLock()
+	defer b.RUnlock()
+	return b.upgrade.reserved
+}
+
+// Регистрирует установленное upgraded соединение, которое нужно закрыть при drain
+func (b *Backend) TrackUpgraded(c io.Closer) {
+	b.Lock()
+	if b.upgrade.conns == nil {
+		b.upgrade.conns = make(map[io.Closer]struct{})
+	}
+	b.upgrade.conns[c] = struct{}{}
+	b.Unlock()
+}
+
+// Снимает соединение с учета и освобождает его место
+func (b *Backend) UntrackUpgraded(c io.Closer) {
+	b.Lock()
+	delete(b.upgrade.conns, c)
+	if b.upgrade.reserved > 0 {
+		b.upgrade.reserved--
+	}
+	b.Unlock()
+}
+
+// Закрывает все upgraded соединения бэкэнда, клиентские соединения закрывает прокси
+func (b *Backend) CloseUpgraded() {
+	b.RLock()
+	conns := make([]io.Closer, 0, len(b.upgrade.conns))
+	for c := range b.upgrade.conns {
+		conns = append(conns, c)
+	}
+	b.RUnlock()
+
+	if len(conns) == 0 {
+		return
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	metrics.UpgradeClosed.Add(float64(len(conns)), b.URL.String(), "drain")
+	log.Printf("Backend %s: closed %d upgraded connections", b.URL, len(conns))
+}
+
+// Закрывает upgraded соединения всех бэкэндов пула, например, при завершении работы
+func (p *BackendPool) CloseUpgraded() {
+	p.RLock()
+	backends := make([]*Backend, len(p.Backends))
+	copy(backends, p.Backends)
+	p.RUnlock()
+
+	for _, b := range backends {
+		b.CloseUpgraded()
+	}
+}
@@ -0,0 +1,220 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+var (
+	ErrUnknownTLSVersion  = errors.New("unknown tls version")
+	ErrUnknownCipherSuite = errors.New("unknown or insecure cipher suite")
+	ErrUnknownClientAuth  = errors.New("unknown client_auth mode")
+	ErrInvalidCA          = errors.New("no certificates in ca file")
+	ErrClientCARequired   = errors.New("client_ca_file is required for client_auth")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// Сертификаты HTTPS listener. Конфиг TLS собирается заново при каждом изменении файлов
+// сертификатов или CA и подставляется для новых соединений через GetConfigForClient,
+// уже установленные соединения продолжают работать со старым
+type Store struct {
+	cfg     config.TLSConfig
+	base    *tls.Config
+	current atomic.Pointer[tls.Config]
+}
+
+func NewStore(cfg config.TLSConfig) (*Store, error) {
+	cfg = cfg.WithDefaults()
+
+	minVersion, ok := tlsVersions[cfg.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTLSVersion, cfg.MinVersion)
+	}
+	clientAuth, ok := clientAuthModes[cfg.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownClientAuth, cfg.ClientAuth)
+	}
+	if cfg.VerifiesClientCert() && cfg.ClientCAFile == "" {
+		return nil, fmt.Errorf("%w: %s", ErrClientCARequired, cfg.ClientAuth)
+	}
+
+	var cipherSuites []uint16
+	if len(cfg.CipherSuites) > 0 {
+		known := make(map[string]uint16)
+		for _, cs := range tls.CipherSuites() {
+			known[cs.Name] = cs.ID
+		}
+		for _, name := range cfg.CipherSuites {
+			id, ok := known[name]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownCipherSuite, name)
+			}
+			cipherSuites = append(cipherSuites, id)
+		}
+	}
+
+	s := &Store{
+		cfg: cfg,
+		base: &tls.Config{
+			MinVersion:   minVersion,
+			CipherSuites: cipherSuites,
+			ClientAuth:   clientAuth,
+			NextProtos:   []string{"h2", "http/1.1"},
+		},
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Конфиг для http.Server: сертификаты и CA берутся из последней успешной загрузки
+func (s *Store) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: s.base.MinVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.current.Load(), nil
+		},
+	}
+}
+
+// Перечитывает файлы сертификатов и CA. При ошибке остаются ранее загруженные
+func (s *Store) Reload() error {
+	cfg := s.base.Clone()
+
+	// Сертификат для соединения выбирается по SNI среди Certificates,
+	// если ни один не подошел - используется первый
+	for _, cc := range s.cfg.Certificates {
+		cert, err := tls.LoadX509KeyPair(cc.CertFile, cc.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate %s: %w", cc.CertFile, err)
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+
+	if s.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(s.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("load client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: %s", ErrInvalidCA, s.cfg.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	s.current.Store(cfg)
+	return nil
+}
+
+// Следит за изменением файлов сертификатов и CA и перечитывает их
+func (s *Store) Watch(ctx context.Context) {
+	files := make([]string, 0, len(s.cfg.Certificates)*2+1)
+	for _, cc := range s.cfg.Certificates {
+		files = append(files, cc.CertFile, cc.KeyFile)
+	}
+	if s.cfg.ClientCAFile != "" {
+		files = append(files, s.cfg.ClientCAFile)
+	}
+
+	last := filesState(files)
+	ticker := time.NewTicker(time.Duration(s.cfg.ReloadInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := filesState(files)
+			if current == last {
+				continue
+			}
+			last = current
+			// Файлы сертификата и ключа часто обновляются не одновременно: если пара еще
+			// не сходится, старый сертификат остается, и попытка повторится при следующем изменении
+			if err := s.Reload(); err != nil {
+				log.Printf("TLS certificates not reloaded: %v", err)
+				continue
+			}
+			log.Println("TLS certificates reloaded")
+		}
+	}
+}
+
+// Время изменения и размер файлов, по изменению этой строки файлы перечитываются
+func filesState(files []string) string {
+	var sig string
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			sig += fmt.Sprintf("%s:%d:%d;", f, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return sig
+}
+
+// Состояние файлов CA и клиентского сертификата пула: транспорт пересоздается, когда
+// оно меняется, так подхватываются обновленные на диске сертификаты
+func UpstreamFilesState(cfg config.UpstreamTLSConfig) string {
+	files := make([]string, 0, 3)
+	for _, f := range []string{cfg.CAFile, cfg.CertFile, cfg.KeyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return filesState(files)
+}
+
+// TLS конфиг для соединений с бэкэндами пула
+func UpstreamTLSConfig(cfg config.UpstreamTLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load upstream ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCA, cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load upstream client certificate %s: %w", cfg.CertFile, err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
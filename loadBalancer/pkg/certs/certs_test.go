@@ -0,0 +1,318 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// Выпускает сертификат для name (DNS имя сервера или CN клиента), подписанный CA,
+// и записывает его с ключом в dir. Возвращает пути к файлам
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Подключается к listener с SNI serverName и возвращает сертификат сервера
+func handshake(t *testing.T, ln net.Listener, ca *testCA, serverName string, clientCerts []tls.Certificate) (*x509.Certificate, error) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		ServerName:   serverName,
+		RootCAs:      roots,
+		Certificates: clientCerts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// В TLS 1.3 сервер проверяет сертификат клиента после завершения handshake
+	// на стороне клиента, поэтому успех подтверждается байтом от сервера
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+// Listener, который после успешного handshake отправляет клиенту один байт
+func serveTLS(t *testing.T, store *Store) net.Listener {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", store.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if conn.(*tls.Conn).Handshake() == nil {
+					conn.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestStoreSNI(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	aCert, aKey := ca.issue(t, dir, "a.example.com", 2)
+	bCert, bKey := ca.issue(t, dir, "b.example.com", 3)
+
+	store, err := NewStore(config.TLSConfig{Certificates: []config.CertConfig{
+		{CertFile: aCert, KeyFile: aKey},
+		{CertFile: bCert, KeyFile: bKey},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := serveTLS(t, store)
+
+	tests := []struct {
+		serverName string
+		// Имя, на которое выпущен отданный сертификат
+		want string
+		// Клиент проверяет имя сервера, поэтому сертификат по умолчанию ему не подходит
+		wantErr bool
+	}{
+		{serverName: "a.example.com", want: "a.example.com"},
+		{serverName: "b.example.com", want: "b.example.com"},
+		{serverName: "c.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			cert, err := handshake(t, ln, ca, tt.serverName, nil)
+			if tt.wantErr {
+				var hostErr x509.HostnameError
+				if !errors.As(err, &hostErr) || hostErr.Certificate.Subject.CommonName != "a.example.com" {
+					t.Fatalf("err = %v, want hostname error for the first certificate", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cert.Subject.CommonName != tt.want {
+				t.Errorf("certificate for %s, want %s", cert.Subject.CommonName, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.issue(t, dir, "a.example.com", 2)
+
+	store, err := NewStore(config.TLSConfig{Certificates: []config.CertConfig{{CertFile: certFile, KeyFile: keyFile}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := serveTLS(t, store)
+
+	serial := func() int64 {
+		cert, err := handshake(t, ln, ca, "a.example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.SerialNumber.Int64()
+	}
+
+	// Сертификат обновлен на диске
+	ca.issue(t, dir, "a.example.com", 4)
+	if got := serial(); got != 2 {
+		t.Fatalf("serial before reload = %d, want 2", got)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := serial(); got != 4 {
+		t.Fatalf("serial after reload = %d, want 4", got)
+	}
+
+	// Ключ еще не обновлен: пара не сходится, остается загруженный ранее сертификат
+	otherDir := t.TempDir()
+	newCert, _ := ca.issue(t, otherDir, "a.example.com", 5)
+	data, err := os.ReadFile(newCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Fatal("reload with mismatched key succeeded")
+	}
+	if got := serial(); got != 4 {
+		t.Errorf("serial after failed reload = %d, want 4", got)
+	}
+}
+
+func TestStoreClientAuth(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.issue(t, dir, "lb.example.com", 2)
+	clientCert, clientKey := ca.issue(t, dir, "client", 3)
+	foreignCert, foreignKey := otherCA.issue(t, dir, "foreign", 4)
+
+	load := func(certFile, keyFile string) []tls.Certificate {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []tls.Certificate{cert}
+	}
+
+	tests := []struct {
+		name       string
+		clientAuth string
+		client     []tls.Certificate
+		wantErr    bool
+	}{
+		{name: "verified client", clientAuth: "require_and_verify", client: load(clientCert, clientKey)},
+		{name: "missing client cert", clientAuth: "require_and_verify", wantErr: true},
+		{name: "client cert from other ca", clientAuth: "require_and_verify", client: load(foreignCert, foreignKey), wantErr: true},
+		{name: "verify if given without cert", clientAuth: "verify_if_given"},
+		{name: "verify if given from other ca", clientAuth: "verify_if_given", client: load(foreignCert, foreignKey), wantErr: true},
+		// require только запрашивает сертификат и не проверяет его
+		{name: "require accepts any cert", clientAuth: "require", client: load(foreignCert, foreignKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewStore(config.TLSConfig{
+				Certificates: []config.CertConfig{{CertFile: certFile, KeyFile: keyFile}},
+				ClientAuth:   tt.clientAuth,
+				ClientCAFile: ca.file,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln := serveTLS(t, store)
+
+			_, err = handshake(t, ln, ca, "lb.example.com", tt.client)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewStoreErrors(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.issue(t, dir, "a.example.com", 2)
+	notCA := filepath.Join(dir, "not-ca.pem")
+	if err := os.WriteFile(notCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.TLSConfig
+		want error
+	}{
+		{name: "tls version", cfg: config.TLSConfig{MinVersion: "1.4"}, want: ErrUnknownTLSVersion},
+		{name: "client auth mode", cfg: config.TLSConfig{ClientAuth: "always"}, want: ErrUnknownClientAuth},
+		{name: "cipher suite", cfg: config.TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, want: ErrUnknownCipherSuite},
+		{name: "verify without ca", cfg: config.TLSConfig{ClientAuth: "require_and_verify"}, want: ErrClientCARequired},
+		{name: "verify if given without ca", cfg: config.TLSConfig{ClientAuth: "verify_if_given"}, want: ErrClientCARequired},
+		{name: "invalid ca", cfg: config.TLSConfig{ClientAuth: "require_and_verify", ClientCAFile: notCA}, want: ErrInvalidCA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Certificates = []config.CertConfig{{CertFile: certFile, KeyFile: keyFile}}
+			if _, err := NewStore(tt.cfg); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
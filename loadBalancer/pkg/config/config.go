@@ -1,15 +1,369 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"os"
+	"time"
+
+	"loadBalancer/pkg/proxyproto"
+)
+
+const (
+	defaultShutdownTimeout   = 30
+	defaultMetricsListenPort = 9090
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
 )
 
 type Config struct {
-	ListenPort          int      `json:"listen_port"`
-	Algorithm           string   `json:"algorithm"`
-	Backends            []string `json:"backends"`
-	HealthCheckInterval int      `json:"health_check_interval"`
+	ListenPort          int                   `json:"listen_port"`
+	Algorithm           string                `json:"algorithm"`
+	Protocol            string                `json:"protocol"`
+	Backends            []BackendConfig       `json:"backends"`
+	HealthCheckInterval int                   `json:"health_check_interval"`
+	Hash                HashConfig            `json:"hash"`
+	StickySession       StickyConfig          `json:"sticky_session"`
+	HealthCheck         HealthCheckConfig     `json:"health_check"`
+	OutlierDetection    OutlierConfig         `json:"outlier_detection"`
+	CircuitBreaker      BreakerConfig         `json:"circuit_breaker"`
+	Admin               AdminConfig           `json:"admin"`
+	MetricsListenPort   int                   `json:"metrics_listen_port"`
+	ShutdownTimeout     int                   `json:"shutdown_timeout"`
+	Retry               RetryConfig           `json:"retry"`
+	RetryBudget         RetryBudgetConfig     `json:"retry_budget"`
+	Hedging             HedgingConfig         `json:"hedging"`
+	Pools               map[string]PoolConfig `json:"pools"`
+	Routes              []RouteConfig         `json:"routes"`
+	TLS                 TLSConfig             `json:"tls"`
+	UpstreamTLS         UpstreamTLSConfig     `json:"upstream_tls"`
+	Upgrade             UpgradeConfig         `json:"upgrade"`
+	TCPListeners        []TCPListenerConfig   `json:"tcp_listeners"`
+	ProxyProtocol       ProxyProtocolConfig   `json:"proxy_protocol"`
+	H2C                 bool                  `json:"h2c"`
+}
+
+// Политика повторов запроса на другом бэкэнде. Повторяются только запросы с методами
+// из methods при ошибке соединения или кодах ответа из status_codes. Тело запроса
+// буферизуется до max_body_bytes, запросы с телом больше лимита не повторяются.
+// Пауза между попытками - случайная в [0, min(backoff_max_ms, backoff_base_ms * 2^n)].
+// max_retries не задан - 3 повтора, 0 - без повторов. gRPC вызовы (POST) повторяются, только если
+// путь начинается с одного из grpc_services ("/pkg.Service/" или "/pkg.Service/Method"): повторять
+// можно только идемпотентные unary вызовы, тело потокового вызова заранее не прочитать
+type RetryConfig struct {
+	MaxRetries    *int     `json:"max_retries"`
+	Methods       []string `json:"methods"`
+	StatusCodes   []int    `json:"status_codes"`
+	MaxBodyBytes  int64    `json:"max_body_bytes"`
+	BackoffBaseMs int      `json:"backoff_base_ms"`
+	BackoffMaxMs  int      `json:"backoff_max_ms"`
+	GRPCServices  []string `json:"grpc_services"`
+}
+
+// Бюджет повторов на весь пул: за окно window_ms повторов может быть не больше, чем
+// percent процентов от запросов за то же окно плюс min_retries_per_sec в секунду.
+// Когда бюджет исчерпан, запрос после неудачной попытки сразу завершается 503
+type RetryBudgetConfig struct {
+	Percent          *int `json:"percent"`
+	MinRetriesPerSec *int `json:"min_retries_per_sec"`
+	WindowMs         int  `json:"window_ms"`
+}
+
+// percent и min_retries_per_sec - указатели, чтобы 0 можно было задать явно
+func (r RetryBudgetConfig) WithDefaults() RetryBudgetConfig {
+	if r.Percent == nil || *r.Percent < 0 {
+		percent := 20
+		r.Percent = &percent
+	}
+	if r.MinRetriesPerSec == nil || *r.MinRetriesPerSec < 0 {
+		minRetries := 3
+		r.MinRetriesPerSec = &minRetries
+	}
+	if r.WindowMs <= 0 {
+		r.WindowMs = 10000
+	}
+	return r
+}
+
+// Hedged запросы: если бэкэнд не ответил за delay_ms, копия запроса отправляется на другой
+// бэкэнд, используется первый ответ. Включается для GET и HEAD запросов, путь которых
+// начинается с одного из paths. Если задан percentile, задержкой служит этот перцентиль
+// времени ответа пула (delay_ms используется, пока замеров мало)
+type HedgingConfig struct {
+	Paths      []string `json:"paths"`
+	DelayMs    int      `json:"delay_ms"`
+	Percentile float64  `json:"percentile"`
+}
+
+func (h HedgingConfig) WithDefaults() HedgingConfig {
+	if h.DelayMs <= 0 {
+		h.DelayMs = 100
+	}
+	return h
+}
+
+// Соединения после HTTP Upgrade (WebSocket): соединение закрывается, если по нему ничего
+// не передавалось idle_timeout_ms, на один бэкэнд открывается не больше max_per_backend
+// соединений (0 - без ограничения). При drain или удалении бэкэнда соединения закрываются
+type UpgradeConfig struct {
+	IdleTimeoutMs int `json:"idle_timeout_ms"`
+	MaxPerBackend int `json:"max_per_backend"`
+}
+
+func (u UpgradeConfig) WithDefaults() UpgradeConfig {
+	if u.IdleTimeoutMs <= 0 {
+		u.IdleTimeoutMs = 600000
+	}
+	return u
+}
+
+// PROXY protocol (v1 и v2) на входящих соединениях HTTP, HTTPS и TCP listeners: если балансировщик
+// стоит за другим L4 прокси, адрес клиента берется из заголовка PROXY. Заголовок принимается только
+// от адресов из trusted_cidrs (пустой список - выключено), соединения без заголовка работают
+// как обычно. Заголовок должен прийти за header_timeout_ms
+type ProxyProtocolConfig struct {
+	TrustedCIDRs    []string `json:"trusted_cidrs"`
+	HeaderTimeoutMs int      `json:"header_timeout_ms"`
+}
+
+func (p ProxyProtocolConfig) WithDefaults() ProxyProtocolConfig {
+	if p.HeaderTimeoutMs <= 0 {
+		p.HeaderTimeoutMs = 5000
+	}
+	return p
+}
+
+// HTTPS listener на listen_port (0 - выключен). Сертификат выбирается по SNI из certificates,
+// файлы сертификатов перечитываются при изменении (проверка раз в reload_interval секунд).
+// client_auth включает проверку клиентских сертификатов (mTLS) по client_ca_file.
+// redirect_http - основной HTTP listener отвечает редиректом на HTTPS вместо проксирования
+type TLSConfig struct {
+	ListenPort     int          `json:"listen_port"`
+	Certificates   []CertConfig `json:"certificates"`
+	MinVersion     string       `json:"min_version"`
+	CipherSuites   []string     `json:"cipher_suites"`
+	ClientAuth     string       `json:"client_auth"`
+	ClientCAFile   string       `json:"client_ca_file"`
+	ReloadInterval int          `json:"reload_interval"`
+	RedirectHTTP   bool         `json:"redirect_http"`
+}
+
+// Проверяются ли клиентские сертификаты. request и require только запрашивают сертификат
+// и не проверяют его
+func (t TLSConfig) VerifiesClientCert() bool {
+	return t.ClientAuth == "verify_if_given" || t.ClientAuth == "require_and_verify"
+}
+
+type CertConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+func (t TLSConfig) WithDefaults() TLSConfig {
+	if t.MinVersion == "" {
+		t.MinVersion = "1.2"
+	}
+	if t.ClientAuth == "" {
+		t.ClientAuth = "none"
+	}
+	if t.ReloadInterval <= 0 {
+		t.ReloadInterval = 10
+	}
+	return t
+}
+
+// TLS до бэкэндов со схемой https: ca_file - CA для проверки сертификатов бэкэндов вместо
+// системных, cert_file и key_file - клиентский сертификат (mTLS), server_name - имя для SNI
+// и проверки сертификата вместо хоста из URL. insecure_skip_verify отключает проверку
+// сертификата и предназначен только для тестовых окружений
+type UpstreamTLSConfig struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// Admin API на отдельном порту, listen_port 0 - admin API выключен.
+// Запросы авторизуются заголовком "Authorization: Bearer <token>"
+type AdminConfig struct {
+	ListenPort int    `json:"listen_port"`
+	Token      string `json:"token"`
+}
+
+// Активная HTTP проверка бэкэндов. Бэкэнд считается живым, если ответ пришел за timeout_ms,
+// код ответа в диапазоне [status_min, status_max] и тело содержит body_contains (если задано).
+// type "tcp" - вместо HTTP запроса проверяется только установка TCP соединения, так же
+// проверяются бэкэнды со схемой tcp://. type "grpc" - проверка по gRPC Health Checking Protocol
+// (grpc.health.v1.Health/Check) для сервиса service, пула с protocol h2 или h2c. Состояние меняется только после rise успешных
+// или fall неуспешных проверок подряд
+type HealthCheckConfig struct {
+	Type         string `json:"type"`
+	Service      string `json:"service"`
+	Path         string `json:"path"`
+	Method       string `json:"method"`
+	StatusMin    int    `json:"status_min"`
+	StatusMax    int    `json:"status_max"`
+	BodyContains string `json:"body_contains"`
+	TimeoutMs    int    `json:"timeout_ms"`
+	Rise         int    `json:"rise"`
+	Fall         int    `json:"fall"`
+}
+
+func (h HealthCheckConfig) WithDefaults() HealthCheckConfig {
+	if h.Type == "" {
+		h.Type = HealthCheckHTTP
+	}
+	if h.Path == "" {
+		h.Path = "/"
+	}
+	if h.Method == "" {
+		h.Method = "GET"
+	}
+	if h.StatusMin == 0 {
+		h.StatusMin = 200
+	}
+	if h.StatusMax == 0 {
+		h.StatusMax = 399
+	}
+	if h.TimeoutMs <= 0 {
+		h.TimeoutMs = 2000
+	}
+	if h.Rise <= 0 {
+		h.Rise = 2
+	}
+	if h.Fall <= 0 {
+		h.Fall = 3
+	}
+	return h
+}
+
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckGRPC = "grpc"
+)
+
+// Протокол до бэкэндов пула: http - HTTP/1.1 (HTTP/2 по ALPN для https), h2 - только HTTP/2
+// поверх TLS, h2c - HTTP/2 без TLS (prior knowledge), как обычно работают gRPC сервисы внутри сети
+const (
+	ProtocolHTTP = "http"
+	ProtocolH2   = "h2"
+	ProtocolH2C  = "h2c"
+)
+
+// Настройки алгоритма consistent_hash: key - источник ключа (ip, header, cookie, path),
+// name - имя заголовка или cookie, virtual_nodes - число виртуальных узлов на бэкэнд
+type HashConfig struct {
+	Key          string `json:"key"`
+	Name         string `json:"name"`
+	VirtualNodes int    `json:"virtual_nodes"`
+}
+
+// Бэкэнд в конфиге можно задать либо строкой с URL, либо объектом с весом:
+// {"url": "http://localhost:8081", "weight": 4}
+type BackendConfig struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Привязка клиента к бэкэнду через подписанную cookie, которую выставляет балансировщик.
+// max_age в секундах, 0 - cookie живет до закрытия браузера
+type StickyConfig struct {
+	Enabled    bool   `json:"enabled"`
+	CookieName string `json:"cookie_name"`
+	Secret     string `json:"secret"`
+	MaxAge     int    `json:"max_age"`
+}
+
+// Пассивная проверка бэкэндов по проксируемым запросам. Бэкэнд исключается из балансировки,
+// если подряд получено consecutive_errors ошибок (5xx или ошибка транспорта) либо доля ошибок
+// за окно window_ms не меньше error_rate_percent (при минимум min_requests запросах).
+// Время исключения растет как base_ejection_ms * 2^(n-1) до max_ejection_ms, при этом
+// одновременно может быть исключено не больше max_ejection_percent бэкэндов пула
+type OutlierConfig struct {
+	ConsecutiveErrors  int `json:"consecutive_errors"`
+	ErrorRatePercent   int `json:"error_rate_percent"`
+	MinRequests        int `json:"min_requests"`
+	WindowMs           int `json:"window_ms"`
+	BaseEjectionMs     int `json:"base_ejection_ms"`
+	MaxEjectionMs      int `json:"max_ejection_ms"`
+	MaxEjectionPercent int `json:"max_ejection_percent"`
+}
+
+func (o OutlierConfig) WithDefaults() OutlierConfig {
+	if o.ConsecutiveErrors <= 0 {
+		o.ConsecutiveErrors = 5
+	}
+	if o.ErrorRatePercent <= 0 {
+		o.ErrorRatePercent = 50
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = 10
+	}
+	if o.WindowMs <= 0 {
+		o.WindowMs = 10000
+	}
+	if o.BaseEjectionMs <= 0 {
+		o.BaseEjectionMs = 30000
+	}
+	if o.MaxEjectionMs <= 0 {
+		o.MaxEjectionMs = 300000
+	}
+	if o.MaxEjectionPercent <= 0 {
+		o.MaxEjectionPercent = 50
+	}
+	return o
+}
+
+// Circuit breaker на каждый бэкэнд: после failure_threshold ошибок подряд breaker открывается
+// и запросы на бэкэнд не отправляются open_timeout_ms, затем пропускается не больше
+// half_open_max_requests пробных запросов, и после success_threshold успешных breaker закрывается
+type BreakerConfig struct {
+	FailureThreshold    int `json:"failure_threshold"`
+	OpenTimeoutMs       int `json:"open_timeout_ms"`
+	HalfOpenMaxRequests int `json:"half_open_max_requests"`
+	SuccessThreshold    int `json:"success_threshold"`
+}
+
+func (b BreakerConfig) WithDefaults() BreakerConfig {
+	if b.FailureThreshold <= 0 {
+		b.FailureThreshold = 5
+	}
+	if b.OpenTimeoutMs <= 0 {
+		b.OpenTimeoutMs = 10000
+	}
+	if b.HalfOpenMaxRequests <= 0 {
+		b.HalfOpenMaxRequests = 1
+	}
+	if b.SuccessThreshold <= 0 {
+		b.SuccessThreshold = 1
+	}
+	if b.SuccessThreshold > b.HalfOpenMaxRequests {
+		b.SuccessThreshold = b.HalfOpenMaxRequests
+	}
+	return b
+}
+
+func (b *BackendConfig) UnmarshalJSON(data []byte) error {
+	var rawURL string
+	if err := json.Unmarshal(data, &rawURL); err == nil {
+		*b = BackendConfig{URL: rawURL, Weight: 1}
+		return nil
+	}
+
+	type plain BackendConfig
+	p := plain{Weight: 1}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*b = BackendConfig(p)
+	return nil
 }
 
 func LoadConfig(filePath string) (*Config, error) {
@@ -24,5 +378,102 @@ func LoadConfig(filePath string) (*Config, error) {
 		return nil, err
 	}
 
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	// Readiness нужен для корректного завершения работы, поэтому порт есть всегда
+	if cfg.MetricsListenPort == 0 {
+		cfg.MetricsListenPort = defaultMetricsListenPort
+	}
+
 	return &cfg, nil
 }
+
+// Проверяет конфиг до применения, чтобы при hot reload невалидный файл не попал в работу
+func (c *Config) Validate() error {
+	if c.ListenPort <= 0 || c.ListenPort > 65535 {
+		return fmt.Errorf("%w: listen_port %d", ErrInvalidConfig, c.ListenPort)
+	}
+	if len(c.Backends) == 0 && len(c.Pools) == 0 {
+		return fmt.Errorf("%w: no backends", ErrInvalidConfig)
+	}
+	// Серверы из корня образуют пул default, пул с тем же именем в pools подменил бы его
+	if _, ok := c.Pools[DefaultPool]; ok && len(c.Backends) > 0 {
+		return fmt.Errorf("%w: pool %q is reserved for root backends", ErrInvalidConfig, DefaultPool)
+	}
+
+	for name, pc := range c.PoolConfigs() {
+		if err := pc.validate(name); err != nil {
+			return err
+		}
+	}
+	if err := c.validateRoutes(); err != nil {
+		return err
+	}
+
+	if c.TLS.ListenPort != 0 {
+		if c.TLS.ListenPort < 0 || c.TLS.ListenPort > 65535 || c.TLS.ListenPort == c.ListenPort {
+			return fmt.Errorf("%w: tls listen_port %d", ErrInvalidConfig, c.TLS.ListenPort)
+		}
+		if len(c.TLS.Certificates) == 0 {
+			return fmt.Errorf("%w: tls certificates are required", ErrInvalidConfig)
+		}
+		// Без client_ca_file клиентские сертификаты проверялись бы по системным CA, и mTLS
+		// проходил бы любой публично выпущенный сертификат
+		if c.TLS.VerifiesClientCert() && c.TLS.ClientCAFile == "" {
+			return fmt.Errorf("%w: tls client_auth %s requires client_ca_file", ErrInvalidConfig, c.TLS.ClientAuth)
+		}
+	}
+
+	if c.MetricsListenPort <= 0 || c.MetricsListenPort > 65535 || c.MetricsListenPort == c.ListenPort ||
+		c.MetricsListenPort == c.TLS.ListenPort || c.MetricsListenPort == c.Admin.ListenPort {
+		return fmt.Errorf("%w: metrics_listen_port %d", ErrInvalidConfig, c.MetricsListenPort)
+	}
+
+	if err := c.validateTCPListeners(); err != nil {
+		return err
+	}
+	if _, err := proxyproto.ParseCIDRs(c.ProxyProtocol.TrustedCIDRs); err != nil {
+		return fmt.Errorf("%w: proxy_protocol trusted_cidrs: %v", ErrInvalidConfig, err)
+	}
+
+	if c.Upgrade.MaxPerBackend < 0 {
+		return fmt.Errorf("%w: upgrade max_per_backend must not be negative", ErrInvalidConfig)
+	}
+	if c.Hedging.Percentile < 0 || c.Hedging.Percentile >= 100 {
+		return fmt.Errorf("%w: hedging percentile must be in [0, 100)", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// Следит за изменением файла конфига (по времени модификации и размеру) и вызывает
+// onChange после каждого изменения. Используется вместе с перечитыванием по SIGHUP
+func Watch(ctx context.Context, filePath string, interval time.Duration, onChange func()) {
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	modTime, size := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			newModTime, newSize := stat()
+			if newModTime.Equal(modTime) && newSize == size {
+				continue
+			}
+			modTime, size = newModTime, newSize
+			log.Printf("Config file %s changed", filePath)
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateTLSClientAuth(t *testing.T) {
+	tests := []struct {
+		clientAuth string
+		caFile     string
+		wantErr    bool
+	}{
+		{clientAuth: "none"},
+		{clientAuth: "request"},
+		{clientAuth: "require"},
+		{clientAuth: "verify_if_given", wantErr: true},
+		{clientAuth: "require_and_verify", wantErr: true},
+		{clientAuth: "verify_if_given", caFile: "ca.pem"},
+		{clientAuth: "require_and_verify", caFile: "ca.pem"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clientAuth+" "+tt.caFile, func(t *testing.T) {
+			cfg := &Config{
+				ListenPort:          8080,
+				MetricsListenPort:   9090,
+				HealthCheckInterval: 5,
+				Backends:            []BackendConfig{{URL: "http://127.0.0.1:1", Weight: 1}},
+				TLS: TLSConfig{
+					ListenPort:   8443,
+					Certificates: []CertConfig{{CertFile: "cert.pem", KeyFile: "key.pem"}},
+					ClientAuth:   tt.clientAuth,
+					ClientCAFile: tt.caFile,
+				},
+			}
+			err := cfg.Validate()
+			if tt.wantErr != errors.Is(err, ErrInvalidConfig) || !tt.wantErr && err != nil {
+				t.Errorf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
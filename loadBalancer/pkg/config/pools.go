@@ -0,0 +1,270 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Пул по умолчанию собирается из корня конфига (backends, algorithm и т.д.) и обслуживает
+// запросы, которые не попали ни в один маршрут
+const DefaultPool = "default"
+
+// Именованный пул бэкэндов со своей стратегией и проверками. Незаданные поля и секции
+// берутся из корня конфига
+type PoolConfig struct {
+	Algorithm           string             `json:"algorithm"`
+	Protocol            string             `json:"protocol"`
+	Backends            []BackendConfig    `json:"backends"`
+	HealthCheckInterval int                `json:"health_check_interval"`
+	Hash                *HashConfig        `json:"hash"`
+	HealthCheck         *HealthCheckConfig `json:"health_check"`
+	OutlierDetection    *OutlierConfig     `json:"outlier_detection"`
+	CircuitBreaker      *BreakerConfig     `json:"circuit_breaker"`
+	RetryBudget         *RetryBudgetConfig `json:"retry_budget"`
+	UpstreamTLS         *UpstreamTLSConfig `json:"upstream_tls"`
+}
+
+// Маршрут: запрос, подходящий под все заданные условия (host, путь, метод, заголовки),
+// проксируется в пул pool. Маршруты проверяются по порядку, срабатывает первый подходящий.
+// host может начинаться с "*." для поддоменов. Перед проксированием путь можно изменить:
+// strip_prefix отрезает path_prefix, rewrite заменяет path_prefix на свое значение, а для
+// path_regex путь заменяется по регулярному выражению (rewrite может ссылаться на группы $1).
+// name нужен, чтобы обращаться к маршруту через admin API, по умолчанию - номер маршрута
+type RouteConfig struct {
+	Name        string            `json:"name"`
+	Host        string            `json:"host"`
+	PathPrefix  string            `json:"path_prefix"`
+	PathRegex   string            `json:"path_regex"`
+	Methods     []string          `json:"methods"`
+	Headers     map[string]string `json:"headers"`
+	Pool        string            `json:"pool"`
+	StripPrefix bool              `json:"strip_prefix"`
+	Rewrite     string            `json:"rewrite"`
+	Hedging     bool              `json:"hedging"`
+	Canary      *CanaryConfig     `json:"canary"`
+	Mirror      *MirrorConfig     `json:"mirror"`
+}
+
+// Canary: percent процентов запросов маршрута уходят в пул pool вместо основного.
+// Запрос с заголовком header (или cookie) со значением value всегда уходит в canary,
+// при пустом value достаточно наличия заголовка (cookie). Остальные запросы делятся по хешу
+// ключа hash_key (ip, header, cookie, по умолчанию ip), hash_name - имя заголовка или cookie,
+// поэтому один и тот же клиент все время попадает в одну и ту же часть трафика
+type CanaryConfig struct {
+	Pool        string `json:"pool"`
+	Percent     int    `json:"percent"`
+	Header      string `json:"header"`
+	HeaderValue string `json:"header_value"`
+	Cookie      string `json:"cookie"`
+	CookieValue string `json:"cookie_value"`
+	HashKey     string `json:"hash_key"`
+	HashName    string `json:"hash_name"`
+}
+
+// Зеркалирование: копия percent процентов запросов маршрута отправляется в пул pool,
+// ответ отбрасывается и не влияет на клиента. Тело копируется до max_body_bytes, запросы
+// с большим телом не зеркалируются. Одновременно выполняется не больше max_in_flight
+// копий, лишние отбрасываются
+type MirrorConfig struct {
+	Pool         string `json:"pool"`
+	Percent      int    `json:"percent"`
+	MaxBodyBytes int64  `json:"max_body_bytes"`
+	TimeoutMs    int    `json:"timeout_ms"`
+	MaxInFlight  int    `json:"max_in_flight"`
+}
+
+func (m MirrorConfig) WithDefaults() MirrorConfig {
+	if m.MaxBodyBytes <= 0 {
+		m.MaxBodyBytes = 64 * 1024
+	}
+	if m.TimeoutMs <= 0 {
+		m.TimeoutMs = 5000
+	}
+	if m.MaxInFlight <= 0 {
+		m.MaxInFlight = 100
+	}
+	return m
+}
+
+// Имя маршрута с номером i: заданное в конфиге или сам номер
+func (r RouteConfig) RouteName(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return strconv.Itoa(i)
+}
+
+// Конфиги всех пулов с учетом наследования от корня. Пул по умолчанию есть,
+// только если в корне заданы backends
+func (c *Config) PoolConfigs() map[string]PoolConfig {
+	root := PoolConfig{
+		Algorithm:           c.Algorithm,
+		Protocol:            c.Protocol,
+		Backends:            c.Backends,
+		HealthCheckInterval: c.HealthCheckInterval,
+		Hash:                &c.Hash,
+		HealthCheck:         &c.HealthCheck,
+		OutlierDetection:    &c.OutlierDetection,
+		CircuitBreaker:      &c.CircuitBreaker,
+		RetryBudget:         &c.RetryBudget,
+		UpstreamTLS:         &c.UpstreamTLS,
+	}
+
+	pools := make(map[string]PoolConfig, len(c.Pools)+1)
+	if len(c.Backends) > 0 {
+		pools[DefaultPool] = root.withDefaultHealthCheckType()
+	}
+	for name, pc := range c.Pools {
+		if pc.Algorithm == "" {
+			pc.Algorithm = root.Algorithm
+		}
+		if pc.Protocol == "" {
+			pc.Protocol = root.Protocol
+		}
+		if pc.HealthCheckInterval <= 0 {
+			pc.HealthCheckInterval = root.HealthCheckInterval
+		}
+		if pc.Hash == nil {
+			pc.Hash = root.Hash
+		}
+		if pc.HealthCheck == nil {
+			pc.HealthCheck = root.HealthCheck
+		}
+		if pc.OutlierDetection == nil {
+			pc.OutlierDetection = root.OutlierDetection
+		}
+		if pc.CircuitBreaker == nil {
+			pc.CircuitBreaker = root.CircuitBreaker
+		}
+		if pc.RetryBudget == nil {
+			pc.RetryBudget = root.RetryBudget
+		}
+		if pc.UpstreamTLS == nil {
+			pc.UpstreamTLS = root.UpstreamTLS
+		}
+		pools[name] = pc.withDefaultHealthCheckType()
+	}
+	return pools
+}
+
+// gRPC серверы обычно не отвечают на HTTP GET, поэтому пулы h2 и h2c без явно заданного
+// health_check.type проверяются по gRPC Health Checking Protocol
+func (pc PoolConfig) withDefaultHealthCheckType() PoolConfig {
+	if pc.HealthCheck.Type != "" || (pc.Protocol != ProtocolH2 && pc.Protocol != ProtocolH2C) {
+		return pc
+	}
+	hc := *pc.HealthCheck
+	hc.Type = HealthCheckGRPC
+	pc.HealthCheck = &hc
+	return pc
+}
+
+func (pc PoolConfig) validate(name string) error {
+	if pc.HealthCheckInterval <= 0 {
+		return fmt.Errorf("%w: pool %s: health_check_interval must be positive", ErrInvalidConfig, name)
+	}
+	if len(pc.Backends) == 0 {
+		return fmt.Errorf("%w: pool %s: no backends", ErrInvalidConfig, name)
+	}
+
+	seen := make(map[string]struct{}, len(pc.Backends))
+	for _, b := range pc.Backends {
+		u, err := url.Parse(b.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w: backend url %q", ErrInvalidConfig, b.URL)
+		}
+		if _, ok := seen[u.String()]; ok {
+			return fmt.Errorf("%w: duplicate backend %q", ErrInvalidConfig, b.URL)
+		}
+		seen[u.String()] = struct{}{}
+		if b.Weight < 0 {
+			return fmt.Errorf("%w: negative weight for %q", ErrInvalidConfig, b.URL)
+		}
+	}
+
+	protocol := pc.Protocol
+	if protocol == "" {
+		protocol = ProtocolHTTP
+	}
+	if protocol != ProtocolHTTP && protocol != ProtocolH2 && protocol != ProtocolH2C {
+		return fmt.Errorf("%w: pool %s: unknown protocol %q", ErrInvalidConfig, name, pc.Protocol)
+	}
+	for _, b := range pc.Backends {
+		u, _ := url.Parse(b.URL)
+		if (protocol == ProtocolH2 && u.Scheme != "https") || (protocol == ProtocolH2C && u.Scheme != "http") {
+			return fmt.Errorf("%w: pool %s: backend %q does not match protocol %s", ErrInvalidConfig, name, b.URL, protocol)
+		}
+	}
+
+	hc := pc.HealthCheck.WithDefaults()
+	if hc.StatusMin > hc.StatusMax {
+		return fmt.Errorf("%w: pool %s: health_check status_min > status_max", ErrInvalidConfig, name)
+	}
+
+	if hc.Type != HealthCheckHTTP && hc.Type != HealthCheckTCP && hc.Type != HealthCheckGRPC {
+		return fmt.Errorf("%w: pool %s: unknown health_check type %q", ErrInvalidConfig, name, hc.Type)
+	}
+	if hc.Type == HealthCheckGRPC && protocol == ProtocolHTTP {
+		return fmt.Errorf("%w: pool %s: grpc health_check requires protocol h2 or h2c", ErrInvalidConfig, name)
+	}
+
+	if (pc.UpstreamTLS.CertFile == "") != (pc.UpstreamTLS.KeyFile == "") {
+		return fmt.Errorf("%w: pool %s: upstream_tls cert_file and key_file must be set together", ErrInvalidConfig, name)
+	}
+
+	return nil
+}
+
+func (c *Config) validateRoutes() error {
+	pools := c.PoolConfigs()
+	names := make(map[string]struct{}, len(c.Routes))
+	for i, r := range c.Routes {
+		if _, ok := names[r.RouteName(i)]; ok {
+			return fmt.Errorf("%w: duplicate route name %q", ErrInvalidConfig, r.RouteName(i))
+		}
+		names[r.RouteName(i)] = struct{}{}
+		if _, ok := pools[r.Pool]; !ok {
+			return fmt.Errorf("%w: route %d: unknown pool %q", ErrInvalidConfig, i, r.Pool)
+		}
+		if r.Canary != nil {
+			if _, ok := pools[r.Canary.Pool]; !ok {
+				return fmt.Errorf("%w: route %d: unknown canary pool %q", ErrInvalidConfig, i, r.Canary.Pool)
+			}
+			if r.Canary.Percent < 0 || r.Canary.Percent > 100 {
+				return fmt.Errorf("%w: route %d: canary percent must be in [0, 100]", ErrInvalidConfig, i)
+			}
+			switch r.Canary.HashKey {
+			case "", "ip":
+			case "header", "cookie":
+				if r.Canary.HashName == "" {
+					return fmt.Errorf("%w: route %d: canary hash_name is required for hash_key %s", ErrInvalidConfig, i, r.Canary.HashKey)
+				}
+			default:
+				return fmt.Errorf("%w: route %d: unknown canary hash_key %q", ErrInvalidConfig, i, r.Canary.HashKey)
+			}
+		}
+		if r.Mirror != nil {
+			if _, ok := pools[r.Mirror.Pool]; !ok {
+				return fmt.Errorf("%w: route %d: unknown mirror pool %q", ErrInvalidConfig, i, r.Mirror.Pool)
+			}
+			if r.Mirror.Percent < 0 || r.Mirror.Percent > 100 {
+				return fmt.Errorf("%w: route %d: mirror percent must be in [0, 100]", ErrInvalidConfig, i)
+			}
+		}
+		if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+			return fmt.Errorf("%w: route %d: path_prefix must start with /", ErrInvalidConfig, i)
+		}
+		if r.PathPrefix != "" && r.PathRegex != "" {
+			return fmt.Errorf("%w: route %d: path_prefix and path_regex are mutually exclusive", ErrInvalidConfig, i)
+		}
+		if r.PathRegex != "" {
+			if _, err := regexp.Compile(r.PathRegex); err != nil {
+				return fmt.Errorf("%w: route %d: path_regex: %v", ErrInvalidConfig, i, err)
+			}
+		}
+	}
+	return nil
+}
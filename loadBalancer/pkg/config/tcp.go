@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// TCP listener (L4): каждое входящее соединение на listen_port проксируется на бэкэнд пула
+// pool, выбранный стратегией пула, байты копируются в обе стороны без разбора протокола.
+// Бэкэнды такого пула задаются как tcp://host:port и проверяются установкой соединения.
+// Соединение закрывается, если по нему ничего не передавалось idle_timeout_ms. Одновременно
+// принимается не больше max_connections соединений, на один бэкэнд - не больше
+// max_per_backend (0 - без ограничения). send_proxy_protocol (1 или 2) - перед данными клиента
+// бэкэнду отправляется заголовок PROXY protocol этой версии с адресом клиента
+type TCPListenerConfig struct {
+	Name              string `json:"name"`
+	ListenPort        int    `json:"listen_port"`
+	Pool              string `json:"pool"`
+	ConnectTimeoutMs  int    `json:"connect_timeout_ms"`
+	IdleTimeoutMs     int    `json:"idle_timeout_ms"`
+	MaxConnections    int    `json:"max_connections"`
+	MaxPerBackend     int    `json:"max_per_backend"`
+	SendProxyProtocol int    `json:"send_proxy_protocol"`
+}
+
+func (t TCPListenerConfig) WithDefaults() TCPListenerConfig {
+	if t.Name == "" {
+		t.Name = "tcp:" + strconv.Itoa(t.ListenPort)
+	}
+	if t.ConnectTimeoutMs <= 0 {
+		t.ConnectTimeoutMs = 5000
+	}
+	if t.IdleTimeoutMs <= 0 {
+		t.IdleTimeoutMs = 600000
+	}
+	return t
+}
+
+// Число tcp:// бэкэндов пула. Пул из tcp:// бэкэндов обслуживает только TCP listeners:
+// HTTP запросы на такие бэкэнды не отправить, поэтому он не может быть пулом по умолчанию
+// или целью маршрута
+func (pc PoolConfig) tcpBackends() int {
+	n := 0
+	for _, b := range pc.Backends {
+		if u, err := url.Parse(b.URL); err == nil && u.Scheme == "tcp" {
+			n++
+		}
+	}
+	return n
+}
+
+func (pc PoolConfig) isTCP() bool {
+	return pc.tcpBackends() > 0
+}
+
+func (c *Config) validateTCPListeners() error {
+	pools := c.PoolConfigs()
+	for name, pc := range pools {
+		if n := pc.tcpBackends(); n > 0 && n < len(pc.Backends) {
+			return fmt.Errorf("%w: pool %s: tcp:// and http(s):// backends can not be mixed", ErrInvalidConfig, name)
+		}
+	}
+	if pc, ok := pools[DefaultPool]; ok && pc.isTCP() {
+		return fmt.Errorf("%w: pool %s of tcp:// backends can not serve HTTP listener", ErrInvalidConfig, DefaultPool)
+	}
+	for i, r := range c.Routes {
+		targets := []string{r.Pool}
+		if r.Canary != nil {
+			targets = append(targets, r.Canary.Pool)
+		}
+		if r.Mirror != nil {
+			targets = append(targets, r.Mirror.Pool)
+		}
+		for _, name := range targets {
+			if pools[name].isTCP() {
+				return fmt.Errorf("%w: route %d: pool %s of tcp:// backends can not serve HTTP requests", ErrInvalidConfig, i, name)
+			}
+		}
+	}
+
+	ports := map[int]struct{}{c.ListenPort: {}}
+	if c.TLS.ListenPort != 0 {
+		ports[c.TLS.ListenPort] = struct{}{}
+	}
+	if c.Admin.ListenPort != 0 {
+		ports[c.Admin.ListenPort] = struct{}{}
+	}
+	ports[c.MetricsListenPort] = struct{}{}
+
+	for i, l := range c.TCPListeners {
+		if l.ListenPort <= 0 || l.ListenPort > 65535 {
+			return fmt.Errorf("%w: tcp listener %d: listen_port %d", ErrInvalidConfig, i, l.ListenPort)
+		}
+		if _, ok := ports[l.ListenPort]; ok {
+			return fmt.Errorf("%w: tcp listener %d: port %d is already used", ErrInvalidConfig, i, l.ListenPort)
+		}
+		ports[l.ListenPort] = struct{}{}
+
+		if l.MaxConnections < 0 || l.MaxPerBackend < 0 {
+			return fmt.Errorf("%w: tcp listener %d: connection limits must not be negative", ErrInvalidConfig, i)
+		}
+
+		if l.SendProxyProtocol < 0 || l.SendProxyProtocol > 2 {
+			return fmt.Errorf("%w: tcp listener %d: send_proxy_protocol must be 1 or 2", ErrInvalidConfig, i)
+		}
+
+		pc, ok := pools[l.Pool]
+		if !ok {
+			return fmt.Errorf("%w: tcp listener %d: unknown pool %q", ErrInvalidConfig, i, l.Pool)
+		}
+		// Адрес бэкэнда берется из URL как есть, поэтому порт должен быть указан явно
+		for _, b := range pc.Backends {
+			u, err := url.Parse(b.URL)
+			if err != nil {
+				return fmt.Errorf("%w: backend url %q", ErrInvalidConfig, b.URL)
+			}
+			if _, _, err := net.SplitHostPort(u.Host); err != nil {
+				return fmt.Errorf("%w: tcp listener %d: backend %q has no port", ErrInvalidConfig, i, b.URL)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+)
+
+var (
+	ErrRouteNotFound  = errors.New("route not found")
+	ErrNoCanary       = errors.New("route has no canary")
+	ErrInvalidPercent = errors.New("percent must be in [0, 100]")
+)
+
+// Доля трафика маршрута, которая уходит в canary пул. Процент меняется во время
+// работы через admin API или перечитывание конфига
+type canary struct {
+	route       string
+	pool        string
+	handler     http.Handler
+	percent     atomic.Int32
+	header      string
+	headerValue string
+	cookie      string
+	cookieValue string
+	hashKey     string
+	hashName    string
+}
+
+func newCanary(route string, cfg config.CanaryConfig, handler http.Handler) *canary {
+	c := &canary{
+		route:       route,
+		pool:        cfg.Pool,
+		handler:     handler,
+		header:      cfg.Header,
+		headerValue: cfg.HeaderValue,
+		cookie:      cfg.Cookie,
+		cookieValue: cfg.CookieValue,
+		hashKey:     cfg.HashKey,
+		hashName:    cfg.HashName,
+	}
+	c.percent.Store(int32(cfg.Percent))
+	return c
+}
+
+// Уходит ли запрос в canary: принудительно по заголовку или cookie, иначе по хешу ключа
+// запроса. Клиент попадает в canary, пока его корзина (0-99) меньше percent, поэтому при
+// увеличении percent клиенты уже в canary там и остаются. Имя маршрута входит в хеш, чтобы
+// в canary разных маршрутов попадали разные клиенты
+func (c *canary) pick(req *http.Request) bool {
+	if c.header != "" {
+		if v := req.Header.Get(c.header); v != "" && (c.headerValue == "" || v == c.headerValue) {
+			return true
+		}
+	}
+	if c.cookie != "" {
+		if cookie, err := req.Cookie(c.cookie); err == nil && cookie.Value != "" &&
+			(c.cookieValue == "" || cookie.Value == c.cookieValue) {
+			return true
+		}
+	}
+
+	percent := int(c.percent.Load())
+	return percent > 0 && c.bucket(req) < percent
+}
+
+func (c *canary) bucket(req *http.Request) int {
+	key := backend.RequestKey(req, c.hashKey, c.hashName)
+	return int(crc32.ChecksumIEEE([]byte(c.route+"\x00"+key)) % 100)
+}
+
+type CanaryStatus struct {
+	Pool    string `json:"pool"`
+	Percent int    `json:"percent"`
+}
+
+type RouteStatus struct {
+	Name   string        `json:"name"`
+	Pool   string        `json:"pool"`
+	Canary *CanaryStatus `json:"canary,omitempty"`
+}
+
+func (r *Router) Routes() []RouteStatus {
+	result := make([]RouteStatus, 0, len(r.routes))
+	for _, rt := range r.routes {
+		status := RouteStatus{Name: rt.name, Pool: rt.pool}
+		if rt.canary != nil {
+			status.Canary = &CanaryStatus{Pool: rt.canary.pool, Percent: int(rt.canary.percent.Load())}
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+func (r *Router) SetCanaryPercent(name string, percent int) error {
+	if percent < 0 || percent > 100 {
+		return ErrInvalidPercent
+	}
+
+	for _, rt := range r.routes {
+		if rt.name != name {
+			continue
+		}
+		if rt.canary == nil {
+			return ErrNoCanary
+		}
+		rt.canary.percent.Store(int32(percent))
+		log.Printf("Route %s: %d%% of traffic goes to canary pool %s", name, percent, rt.canary.pool)
+		return nil
+	}
+
+	return ErrRouteNotFound
+}
+
+// Применяет проценты canary, изменившиеся в перечитанном конфиге по сравнению с previous.
+// Проценты, выставленные через admin API, сохраняются, пока их не изменят в конфиге
+func (r *Router) ApplyCanary(previous, routes []config.RouteConfig) {
+	old := make(map[string]int, len(previous))
+	for i, rc := range previous {
+		if rc.Canary != nil {
+			old[rc.RouteName(i)] = rc.Canary.Percent
+		}
+	}
+
+	for i, rc := range routes {
+		if rc.Canary == nil {
+			continue
+		}
+		if percent, ok := old[rc.RouteName(i)]; ok && percent == rc.Canary.Percent {
+			continue
+		}
+		if err := r.SetCanaryPercent(rc.RouteName(i), rc.Canary.Percent); err != nil {
+			log.Printf("Route %s: canary not applied: %v", rc.RouteName(i), err)
+		}
+	}
+}
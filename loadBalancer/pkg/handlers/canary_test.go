@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"loadBalancer/pkg/config"
+)
+
+func TestCanaryStableSplit(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.CanaryConfig
+		// Задает ключ клиента i в запросе
+		key func(i int) (remoteAddr, header string)
+	}{
+		{name: "ip", cfg: config.CanaryConfig{Percent: 30}, key: func(i int) (string, string) {
+			return fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256), ""
+		}},
+		{name: "header", cfg: config.CanaryConfig{Percent: 30, HashKey: "header", HashName: "X-User"}, key: func(i int) (string, string) {
+			return "10.0.0.1:1234", fmt.Sprintf("user-%d", i)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCanary("web", tt.cfg, nil)
+			const clients = 2000
+			picked := 0
+			for i := 0; i < clients; i++ {
+				remoteAddr, header := tt.key(i)
+				first := false
+				for j := 0; j < 5; j++ {
+					req := httptest.NewRequest("GET", "/", nil)
+					// Порт меняется от запроса к запросу, на выбор он влиять не должен
+					req.RemoteAddr = remoteAddr[:len(remoteAddr)-1] + fmt.Sprint(j)
+					if header != "" {
+						req.Header.Set("X-User", header)
+					}
+					got := c.pick(req)
+					if j == 0 {
+						first = got
+					} else if got != first {
+						t.Fatalf("client %d: pick changed between requests", i)
+					}
+				}
+				if first {
+					picked++
+				}
+			}
+			if share := picked * 100 / clients; share < 25 || share > 35 {
+				t.Errorf("canary share = %d%%, want about %d%%", share, tt.cfg.Percent)
+			}
+		})
+	}
+}
+
+func TestCanaryPercentChangeKeepsClients(t *testing.T) {
+	c := newCanary("web", config.CanaryConfig{Percent: 10}, nil)
+	for i := 0; i < 1000; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = fmt.Sprintf("10.1.%d.%d:1234", i/256, i%256)
+		c.percent.Store(10)
+		before := c.pick(req)
+		c.percent.Store(50)
+		if before && !c.pick(req) {
+			t.Fatalf("client %s left canary when percent grew", req.RemoteAddr)
+		}
+	}
+}
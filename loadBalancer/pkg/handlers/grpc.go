@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Коды gRPC, соответствующие им коды HTTP - как в grpc-gateway и Envoy
+var grpcHTTPStatus = map[int]int{
+	0:  http.StatusOK,
+	1:  499, // CANCELLED: клиент закрыл запрос
+	2:  http.StatusInternalServerError,
+	3:  http.StatusBadRequest,
+	4:  http.StatusGatewayTimeout,
+	5:  http.StatusNotFound,
+	6:  http.StatusConflict,
+	7:  http.StatusForbidden,
+	8:  http.StatusTooManyRequests,
+	9:  http.StatusBadRequest,
+	10: http.StatusConflict,
+	11: http.StatusBadRequest,
+	12: http.StatusNotImplemented,
+	13: http.StatusInternalServerError,
+	14: http.StatusServiceUnavailable,
+	15: http.StatusInternalServerError,
+	16: http.StatusUnauthorized,
+}
+
+// UNAVAILABLE - так клиент gRPC поймет, что вызов можно повторить
+const (
+	grpcUnknown     = 2
+	grpcUnavailable = 14
+)
+
+// Коды gRPC, которые говорят о проблеме бэкэнда (DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED,
+// UNAVAILABLE). Остальные, в том числе UNIMPLEMENTED и INTERNAL, - ответ приложения на
+// конкретный вызов, поэтому не учитываются в circuit breaker и outlier detection
+var grpcBackendFailures = map[int]struct{}{
+	4:  {},
+	8:  {},
+	14: {},
+}
+
+func isGRPC(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "application/grpc")
+}
+
+// Код gRPC из trailers-only ответа. ok - false, если ответ не gRPC или статус придет в трейлерах.
+// Некорректный grpc-status считается UNKNOWN
+func grpcCode(resp *http.Response) (code int, ok bool) {
+	if resp.StatusCode != http.StatusOK || !isGRPC(resp.Header) {
+		return 0, false
+	}
+	value := resp.Header.Get("Grpc-Status")
+	if value == "" {
+		return 0, false
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return grpcUnknown, true
+	}
+	return code, true
+}
+
+// Код ответа для метрик и retry. gRPC отвечает HTTP 200, а результат вызова передает
+// в grpc-status. Ошибка без сообщений приходит trailers-only ответом со статусом в заголовках -
+// такой статус переводится в код HTTP. Статус из трейлеров становится известен только после
+// передачи ответа клиенту и не учитывается
+func responseStatus(resp *http.Response) int {
+	code, ok := grpcCode(resp)
+	if !ok {
+		return resp.StatusCode
+	}
+	if status, ok := grpcHTTPStatus[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Считается ли ответ отказом бэкэнда для circuit breaker и outlier detection
+func backendFailed(resp *http.Response) bool {
+	if code, ok := grpcCode(resp); ok {
+		_, failed := grpcBackendFailures[code]
+		return failed
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// Ошибка балансировщика для gRPC клиента: trailers-only ответ со статусом UNAVAILABLE,
+// иначе клиент получил бы непонятный ему ответ HTTP 503 с текстом
+func grpcError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(grpcUnavailable))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
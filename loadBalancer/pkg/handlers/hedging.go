@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/metrics"
+)
+
+type hedgingKey struct{}
+
+// Помечает запрос маршрута, для которого включен hedging
+func withHedging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hedgingKey{}, true)
+}
+
+type HedgePolicy struct {
+	Delay      time.Duration
+	Percentile float64
+	paths      []string
+}
+
+// Hedging включается для путей из paths и для маршрутов с флагом hedging
+func NewHedgePolicy(cfg config.HedgingConfig) *HedgePolicy {
+	cfg = cfg.WithDefaults()
+	return &HedgePolicy{
+		Delay:      time.Duration(cfg.DelayMs) * time.Millisecond,
+		Percentile: cfg.Percentile,
+		paths:      cfg.Paths,
+	}
+}
+
+// Копию можно отправлять только для идемпотентных запросов без тела или с уже
+// буферизованным телом
+func (h *HedgePolicy) Applies(req *http.Request) bool {
+	if h == nil {
+		return false
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if hedged, _ := req.Context().Value(hedgingKey{}).(bool); hedged {
+		return true
+	}
+	for _, prefix := range h.paths {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HedgePolicy) delay(pool *backend.BackendPool) time.Duration {
+	if h.Percentile > 0 {
+		if d, ok := pool.LatencyPercentile(h.Percentile); ok {
+			return d
+		}
+	}
+	return h.Delay
+}
+
+type attemptResult struct {
+	b      *backend.Backend
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+	hedge  bool
+	idx    int
+}
+
+// Отправляет запрос на b, а если ответ не пришел за задержку hedging, отправляет копию
+// на другой бэкэнд. Возвращается первый ответ без 5xx, остальные попытки отменяются. Ответ 5xx
+// придерживается, пока не завершатся остальные попытки, и возвращается, только если лучшего
+// ответа не было. Копия тратит бюджет повторов, чтобы при общей деградации не удваивать нагрузку
+func (t *CustomTransport) sendHedged(req *http.Request, b *backend.Backend, tried map[*backend.Backend]struct{}) (*backend.Backend, *http.Response, error) {
+	results := make(chan attemptResult, 2)
+	var cancels []context.CancelFunc
+	start := func(b *backend.Backend, hedge bool) {
+		ctx, cancel := context.WithCancel(req.Context())
+		idx := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := t.send(ctx, req, b)
+			results <- attemptResult{b: b, resp: resp, err: err, cancel: cancel, hedge: hedge, idx: idx}
+		}()
+	}
+
+	start(b, false)
+	pending := 1
+
+	timer := time.NewTimer(t.Hedge.delay(t.Pool))
+	defer timer.Stop()
+
+	var last, fallback attemptResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			hb := t.Pool.NextBackendExcluding(req, tried)
+			if hb == nil || !hb.Breaker.Allow() {
+				continue
+			}
+			if !t.Pool.RetryBudget().AllowRetry() {
+				metrics.RetryBudgetExhausted.Inc(t.Pool.Name)
+				hb.Breaker.Release()
+				continue
+			}
+			tried[hb] = struct{}{}
+			metrics.HedgedRequests.Inc(hb.URL.String())
+			log.Printf("Hedging request to %s after no response from %s", hb.URL, b.URL)
+			start(hb, true)
+			pending++
+
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				r.cancel()
+				last = r
+				continue
+			}
+			if responseStatus(r.resp) >= http.StatusInternalServerError && pending > 0 {
+				if fallback.resp == nil {
+					fallback = r
+				} else {
+					drainBody(r.resp)
+					r.cancel()
+				}
+				continue
+			}
+
+			if fallback.resp != nil {
+				drainBody(fallback.resp)
+				fallback.cancel()
+			}
+			return hedgeWinner(r, cancels, pending, results)
+		}
+	}
+
+	if fallback.resp != nil {
+		return hedgeWinner(fallback, cancels, 0, results)
+	}
+	return last.b, nil, last.err
+}
+
+// Отменяет проигравшие попытки (их ответы закрываются в фоне) и возвращает ответ r
+func hedgeWinner(r attemptResult, cancels []context.CancelFunc, pending int, results <-chan attemptResult) (*backend.Backend, *http.Response, error) {
+	for i, cancel := range cancels {
+		if i != r.idx {
+			cancel()
+		}
+	}
+	if pending > 0 {
+		go func(n int) {
+			for i := 0; i < n; i++ {
+				if lost := <-results; lost.resp != nil {
+					lost.resp.Body.Close()
+				}
+			}
+		}(pending)
+	}
+	if r.hedge {
+		metrics.HedgeWins.Inc(r.b.URL.String())
+	}
+	r.resp.Body = &cancelOnClose{ReadCloser: r.resp.Body, cancel: r.cancel}
+	return r.b, r.resp, nil
+}
+
+// Отменяет контекст попытки после того, как тело ответа прочитано и закрыто
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+)
+
+func TestHedgePolicyApplies(t *testing.T) {
+	h := NewHedgePolicy(config.HedgingConfig{Paths: []string{"/search"}})
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// Тело уже буферизовано для повторов
+		buffered bool
+		route    bool
+		want     bool
+	}{
+		{name: "path prefix", method: "GET", path: "/search?q=x", want: true},
+		{name: "other path", method: "GET", path: "/items", want: false},
+		{name: "route flag", method: "GET", path: "/items", route: true, want: true},
+		{name: "head", method: "HEAD", path: "/search", want: true},
+		{name: "post", method: "POST", path: "/search", want: false},
+		{name: "streamed body", method: "GET", path: "/search", body: "x", want: false},
+		{name: "buffered body", method: "GET", path: "/search", body: "x", buffered: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.body != "" {
+				req = httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+				if tt.buffered {
+					req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(tt.body)), nil }
+				}
+			} else {
+				req = httptest.NewRequest(tt.method, tt.path, nil)
+			}
+			if tt.route {
+				req = req.WithContext(withHedging(req.Context()))
+			}
+			if got := h.Applies(req); got != tt.want {
+				t.Errorf("Applies = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	var nilPolicy *HedgePolicy
+	if nilPolicy.Applies(httptest.NewRequest("GET", "/search", nil)) {
+		t.Error("nil policy applies")
+	}
+}
+
+// Поведение бэкэнда в тесте: задержка перед ответом и код ответа
+type hedgeReply struct {
+	delay  time.Duration
+	status int
+}
+
+func TestSendHedged(t *testing.T) {
+	const slow = time.Second
+	tests := []struct {
+		name    string
+		replies map[string]hedgeReply
+		// Бюджет повторов исчерпан, копия не отправляется
+		noBudget   bool
+		wantStatus int
+		wantHosts  []string
+		// Бэкэнды, чьи попытки должны быть отменены
+		wantCanceled []string
+	}{
+		{
+			name:       "fast primary",
+			replies:    map[string]hedgeReply{"a.local": {status: 200}, "b.local": {status: 200}},
+			wantStatus: 200,
+			wantHosts:  []string{"a.local"},
+		},
+		{
+			name:         "slow primary loses to hedge",
+			replies:      map[string]hedgeReply{"a.local": {delay: slow, status: 200}, "b.local": {status: 201}},
+			wantStatus:   201,
+			wantHosts:    []string{"a.local", "b.local"},
+			wantCanceled: []string{"a.local"},
+		},
+		{
+			name:       "hedge 5xx held until primary answers",
+			replies:    map[string]hedgeReply{"a.local": {delay: 50 * time.Millisecond, status: 200}, "b.local": {status: 503}},
+			wantStatus: 200,
+			wantHosts:  []string{"a.local", "b.local"},
+		},
+		{
+			// Лучшего ответа нет, отдается ответ последней завершившейся попытки
+			name:       "both 5xx",
+			replies:    map[string]hedgeReply{"a.local": {delay: 50 * time.Millisecond, status: 502}, "b.local": {status: 503}},
+			wantStatus: 502,
+			wantHosts:  []string{"a.local", "b.local"},
+		},
+		{
+			name:       "no retry budget",
+			replies:    map[string]hedgeReply{"a.local": {delay: 50 * time.Millisecond, status: 200}, "b.local": {status: 201}},
+			noBudget:   true,
+			wantStatus: 200,
+			wantHosts:  []string{"a.local"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := backend.NewBackendPool([]config.BackendConfig{
+				{URL: "http://a.local", Weight: 1},
+				{URL: "http://b.local", Weight: 1},
+			})
+			pool.Name = "test"
+			if err := pool.SetAlgorithm(backend.RoundRobinAlg, config.HashConfig{}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.noBudget {
+				zero := 0
+				pool.SetRetryBudget(config.RetryBudgetConfig{Percent: &zero, MinRetriesPerSec: &zero})
+			}
+
+			var mu sync.Mutex
+			var hosts, canceled []string
+			transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				reply := tt.replies[req.URL.Host]
+				mu.Lock()
+				hosts = append(hosts, req.URL.Host)
+				mu.Unlock()
+
+				select {
+				case <-time.After(reply.delay):
+				case <-req.Context().Done():
+					mu.Lock()
+					canceled = append(canceled, req.URL.Host)
+					mu.Unlock()
+					return nil, req.Context().Err()
+				}
+				return &http.Response{StatusCode: reply.status, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+			})
+
+			maxRetries := 0
+			ct := &CustomTransport{
+				RoundTripper: transport,
+				Pool:         pool,
+				Retry:        NewRetryPolicy(config.RetryConfig{MaxRetries: &maxRetries}),
+				Hedge:        NewHedgePolicy(config.HedgingConfig{Paths: []string{"/"}, DelayMs: 10}),
+			}
+			// Первой выбирается a.local
+			for pool.NextBackend(httptest.NewRequest("GET", "/", nil)).URL.Host != "b.local" {
+			}
+
+			req := httptest.NewRequest("GET", "http://lb.local/items", nil)
+			resp, err := ct.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			// Проигравшие попытки отменяются в фоне
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.Join(hosts, ",") != strings.Join(tt.wantHosts, ",") {
+				t.Errorf("attempts = %v, want %v", hosts, tt.wantHosts)
+			}
+			if strings.Join(canceled, ",") != strings.Join(tt.wantCanceled, ",") {
+				t.Errorf("canceled = %v, want %v", canceled, tt.wantCanceled)
+			}
+		})
+	}
+}
+
+// Контекст выигравшей попытки отменяется только после закрытия тела ответа
+func TestCancelOnClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	body := &cancelOnClose{ReadCloser: http.NoBody, cancel: cancel}
+	if ctx.Err() != nil {
+		t.Fatal("canceled before close")
+	}
+	body.Close()
+	if ctx.Err() == nil {
+		t.Error("not canceled after close")
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/metrics"
+)
+
+// Заголовок, которым помечаются копии запросов, чтобы shadow бэкэнд мог их отличить
+const ShadowHeader = "X-Shadow-Request"
+
+// Зеркалирование запросов маршрута в shadow пул. Копия отправляется в фоне, ее ответ
+// отбрасывается, поэтому на задержку и ответ клиенту она не влияет
+type mirror struct {
+	pool         *backend.BackendPool
+	percent      int
+	maxBodyBytes int64
+	timeout      time.Duration
+	inFlight     chan struct{}
+}
+
+func newMirror(cfg config.MirrorConfig, pool *backend.BackendPool) *mirror {
+	cfg = cfg.WithDefaults()
+	return &mirror{
+		pool:         pool,
+		percent:      cfg.Percent,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		timeout:      time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		inFlight:     make(chan struct{}, cfg.MaxInFlight),
+	}
+}
+
+// Отправляет копию запроса, если он попал в выборку. Тело запроса читается и подставляется
+// обратно, чтобы основной запрос получил его целиком, а лимит тела для повторов основного
+// запроса (retry.max_body_bytes) продолжал действовать. Upgrade запросы не зеркалируются:
+// соединение после 101 нельзя ни отбросить, ни разделить с клиентом
+func (m *mirror) send(req *http.Request) {
+	if m.percent <= 0 || isUpgrade(req) || rand.Intn(100) >= m.percent {
+		return
+	}
+
+	body, ok, err := readBody(req, m.maxBodyBytes)
+	if err != nil || !ok {
+		metrics.MirrorDropped.Inc(m.pool.Name, "body_too_large")
+		return
+	}
+
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		metrics.MirrorDropped.Inc(m.pool.Name, "max_in_flight")
+		return
+	}
+
+	b := m.pool.NextBackend(req)
+	if b == nil {
+		<-m.inFlight
+		metrics.MirrorDropped.Inc(m.pool.Name, "no_backend")
+		return
+	}
+
+	// Копия не должна отменяться вместе с запросом клиента
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), m.timeout)
+	shadow := req.Clone(ctx)
+	if body != nil {
+		shadow.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	shadow.GetBody = nil
+	shadow.RequestURI = ""
+	shadow.Host = ""
+	setTarget(shadow.URL, b.URL)
+	// Копия идет мимо ReverseProxy, поэтому заголовки готовятся так же, как у основного запроса
+	removeHopHeaders(shadow.Header)
+	(&httputil.ProxyRequest{In: req, Out: shadow}).SetXForwarded()
+	shadow.Header.Set(ShadowHeader, "1")
+
+	go func() {
+		defer func() { <-m.inFlight }()
+		defer cancel()
+		m.roundTrip(shadow, b)
+	}()
+}
+
+// Hop-by-hop заголовки относятся к соединению клиента и не передаются дальше (RFC 9110),
+// как и в httputil.ReverseProxy
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	// "TE: trailers" нужен gRPC бэкэндам, ReverseProxy его тоже оставляет
+	keepTE := false
+	for _, value := range h.Values("Te") {
+		if strings.Contains(strings.ToLower(value), "trailers") {
+			keepTE = true
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	if keepTE {
+		h.Set("Te", "trailers")
+	}
+}
+
+func (m *mirror) roundTrip(req *http.Request, b *backend.Backend) {
+	b.IncConn()
+	defer b.DecConn()
+
+	start := time.Now()
+	resp, err := m.pool.Transport().RoundTrip(req)
+	if err != nil {
+		metrics.MirrorRequests.Inc(m.pool.Name, b.URL.String(), metrics.StatusClass(0))
+		log.Printf("Mirror request to %s failed: %v", b.URL, err)
+		return
+	}
+	drainBody(resp)
+
+	metrics.MirrorLatency.Observe(time.Since(start).Seconds(), m.pool.Name)
+	metrics.MirrorRequests.Inc(m.pool.Name, b.URL.String(), metrics.StatusClass(responseStatus(resp)))
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+)
+
+// Запрос, который получил shadow бэкэнд
+type shadowRequest struct {
+	header http.Header
+	body   string
+}
+
+func newTestMirror(t *testing.T, cfg config.MirrorConfig) (*mirror, <-chan shadowRequest) {
+	t.Helper()
+	received := make(chan shadowRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- shadowRequest{header: r.Header.Clone(), body: string(body)}
+	}))
+	t.Cleanup(srv.Close)
+
+	pool := backend.NewBackendPool([]config.BackendConfig{{URL: srv.URL, Weight: 1}})
+	pool.Name = "shadow"
+	if err := pool.SetAlgorithm(backend.RoundRobinAlg, config.HashConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	return newMirror(cfg, pool), received
+}
+
+func TestMirrorSend(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.MirrorConfig
+		body   string
+		header map[string]string
+		// Все места для копий заняты
+		busy     bool
+		mirrored bool
+	}{
+		{name: "mirrored", cfg: config.MirrorConfig{Percent: 100}, body: "payload", mirrored: true},
+		{name: "no body", cfg: config.MirrorConfig{Percent: 100}, mirrored: true},
+		{name: "zero percent", cfg: config.MirrorConfig{Percent: 0}, body: "payload"},
+		{name: "body over limit", cfg: config.MirrorConfig{Percent: 100, MaxBodyBytes: 4}, body: "payload"},
+		{name: "upgrade", cfg: config.MirrorConfig{Percent: 100}, header: map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}},
+		{name: "max in flight", cfg: config.MirrorConfig{Percent: 100, MaxInFlight: 1}, body: "payload", busy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, received := newTestMirror(t, tt.cfg)
+			if tt.busy {
+				m.inFlight <- struct{}{}
+			}
+
+			var req *http.Request
+			if tt.body != "" {
+				req = httptest.NewRequest("PUT", "http://lb.local/items", strings.NewReader(tt.body))
+			} else {
+				req = httptest.NewRequest("GET", "http://lb.local/items", nil)
+			}
+			req.Header.Set("Connection", "X-Private")
+			req.Header.Set("X-Private", "1")
+			req.Header.Set("Keep-Alive", "timeout=5")
+			req.Header.Set("Te", "trailers")
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+
+			m.send(req)
+
+			// Основной запрос получает тело целиком, буфер для повторов не создается
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("original body = %q, want %q", body, tt.body)
+			}
+			if req.GetBody != nil {
+				t.Error("mirror installed GetBody on the original request")
+			}
+			if req.Header.Get(ShadowHeader) != "" {
+				t.Error("shadow header set on the original request")
+			}
+
+			select {
+			case got := <-received:
+				if !tt.mirrored {
+					t.Fatal("request mirrored")
+				}
+				if got.body != tt.body {
+					t.Errorf("shadow body = %q, want %q", got.body, tt.body)
+				}
+				if got.header.Get(ShadowHeader) != "1" {
+					t.Errorf("%s = %q", ShadowHeader, got.header.Get(ShadowHeader))
+				}
+				if got.header.Get("X-Private") != "" || got.header.Get("Keep-Alive") != "" {
+					t.Errorf("hop-by-hop headers passed: %v", got.header)
+				}
+				if got.header.Get("Te") != "trailers" {
+					t.Errorf("Te = %q, want trailers", got.header.Get("Te"))
+				}
+				if got.header.Get("X-Forwarded-For") == "" {
+					t.Error("X-Forwarded-For not set")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.mirrored {
+					t.Fatal("request not mirrored")
+				}
+			}
+		})
+	}
+}
+
+// Зеркалирование не должно снимать лимит тела для повторов основного запроса
+func TestMirrorKeepsRetryBodyLimit(t *testing.T) {
+	m, received := newTestMirror(t, config.MirrorConfig{Percent: 100, MaxBodyBytes: 1024})
+	body := strings.Repeat("x", 100)
+	req := httptest.NewRequest("PUT", "http://lb.local/items", strings.NewReader(body))
+
+	m.send(req)
+	<-received
+
+	retryable, err := bufferBody(req, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retryable {
+		t.Error("body over retry.max_body_bytes became retryable after mirroring")
+	}
+	got, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Errorf("body = %q, want %q", got, body)
+	}
+}
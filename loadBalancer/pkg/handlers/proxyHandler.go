@@ -1,22 +1,36 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"strings"
 	"time"
 
 	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/metrics"
+	"loadBalancer/pkg/middleware"
 )
 
-const (
-	numRetries = 3
+var (
+	ErrNoAvailableBackends  = errors.New("no available backends")
+	ErrCircuitOpen          = errors.New("circuit breaker is open")
+	ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
 )
 
 type CustomTransport struct {
+	// Если не задан, используется транспорт пула (с настройками upstream_tls)
 	http.RoundTripper
-	Retries int
 	Pool    *backend.BackendPool
+	Sticky  *StickySessions
+	Retry   *RetryPolicy
+	Hedge   *HedgePolicy
+	Upgrade *UpgradePolicy
 }
 
 func (t *CustomTransport) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -30,58 +44,297 @@ func (t *CustomTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// можно запоминать в очереди запросы, которые приходили (очередь запросов)
 	// В данном случае принято решение исплользовать политику retry-ев
 
-	for i := 0; i <= t.Retries; i++ {
-		b := t.Pool.NextBackend()
+	info := middleware.GetAccessInfo(req.Context())
+	if info != nil {
+		info.SetPool(t.Pool.Name)
+	}
+	t.Pool.RetryBudget().RecordRequest()
+
+	retryable := t.Retry.RequestRetryable(req)
+	if retryable {
+		if retryable, err = bufferBody(req, t.Retry.MaxBodyBytes); err != nil {
+			return nil, err
+		}
+	}
+	// Upgrade запрос повторяется только до ответа 101, после него соединение
+	// принадлежит клиенту, поэтому hedging для него не применяется
+	upgrade := isUpgrade(req)
+
+	maxAttempts := 1
+	if retryable {
+		maxAttempts += t.Retry.MaxRetries
+	}
+
+	// Каждая попытка идет на бэкэнд, который еще не пробовали
+	tried := make(map[*backend.Backend]struct{})
+
+	backoff := false
+	for attempt := 0; attempt < maxAttempts; {
+		if backoff {
+			if err := sleepContext(req.Context(), t.Retry.Backoff(attempt)); err != nil {
+				if resp != nil {
+					drainBody(resp)
+				}
+				return nil, err
+			}
+			backoff = false
+		}
+
+		var b *backend.Backend
+		// Привязка по cookie действует только на первую попытку, при retry выбираем
+		// бэкэнд стратегией и перезаписываем cookie
+		if t.Sticky != nil && len(tried) == 0 {
+			b = t.Sticky.Backend(t.Pool, req)
+		}
+		if b == nil {
+			b = t.Pool.NextBackendExcluding(req, tried)
+		}
 		if b == nil {
+			// Попробовать больше некого: отдаем последний ответ бэкэнда, если он был
+			if resp != nil {
+				return resp, nil
+			}
 			log.Println("No available backends")
+			if err == nil {
+				err = ErrNoAvailableBackends
+			}
 			return nil, err
 		}
+		tried[b] = struct{}{}
 
 		currentBackendURL := b.URL.String()
-		if lastBackendURL != "" && lastBackendURL != currentBackendURL {
-			log.Printf("Switching from %s to %s", lastBackendURL, currentBackendURL)
+
+		// Открытый breaker отсекает запрос сразу: запрос никуда не ушел, поэтому
+		// это не считается попыткой и не требует паузы
+		if !b.Breaker.Allow() {
+			log.Printf("Circuit breaker for %s is %s, skipping", currentBackendURL, b.Breaker.State())
+			if err == nil {
+				err = ErrCircuitOpen
+			}
+			continue
 		}
+
+		// Бэкэнд с максимумом upgraded соединений пропускается так же, как с открытым breaker
+		if upgrade && !t.Upgrade.acquire(b) {
+			b.Breaker.Release()
+			err = ErrUpgradeLimit
+			continue
+		}
+
+		if attempt > 0 {
+			if resp != nil {
+				drainBody(resp)
+				resp = nil
+			}
+			if info != nil {
+				info.IncRetries()
+			}
+			metrics.Retries.Inc(lastBackendURL)
+			log.Printf("Retry %d: switching from %s to %s", attempt, lastBackendURL, currentBackendURL)
+		}
+		attempt++
 		lastBackendURL = currentBackendURL
+		if info != nil {
+			info.SetBackend(currentBackendURL)
+		}
+
+		if !upgrade && t.Hedge.Applies(req) {
+			b, resp, err = t.sendHedged(req, b, tried)
+			lastBackendURL = b.URL.String()
+			if info != nil {
+				info.SetBackend(lastBackendURL)
+			}
+		} else {
+			resp, err = t.send(req.Context(), req, b)
+		}
+		if upgrade {
+			resp = t.Upgrade.track(resp, err, b)
+		}
+		if err != nil {
+			resp = nil
+			// Запрос отменил клиент: повторять некому, бюджет повторов не тратится
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			if attempt < maxAttempts && !t.allowRetry() {
+				return nil, ErrRetryBudgetExhausted
+			}
+			backoff = true
+			continue
+		}
+
+		if status := responseStatus(resp); retryable && t.Retry.StatusRetryable(status) && attempt < maxAttempts {
+			log.Printf("Backend %s responded %d", lastBackendURL, status)
+			if !t.allowRetry() {
+				drainBody(resp)
+				return nil, ErrRetryBudgetExhausted
+			}
+			backoff = true
+			continue
+		}
+
+		if t.Sticky != nil {
+			t.Sticky.SetCookie(resp, req, b)
+		}
+		return resp, nil
+	}
+
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+// Одна попытка запроса к бэкэнду b: учитывает соединение, время ответа и результат
+// в метриках, circuit breaker и outlier detection. Перед вызовом breaker должен
+// разрешить запрос (Allow)
+func (t *CustomTransport) send(ctx context.Context, req *http.Request, b *backend.Backend) (*http.Response, error) {
+	outReq := req.Clone(ctx)
+	if req.GetBody != nil {
+		outReq.Body, _ = req.GetBody()
+	}
+	setTarget(outReq.URL, b.URL)
+	if t.Sticky != nil {
+		t.Sticky.StripCookie(outReq)
+	}
 
-		req.URL.Scheme = b.URL.Scheme
-		req.URL.Host = b.URL.Host
+	b.IncConn()
+	defer b.DecConn()
 
-		b.IncConn()
-		defer b.DecConn()
+	backendURL := b.URL.String()
 
-		resp, err = t.RoundTripper.RoundTrip(req)
-		if err == nil {
-			b.SetAlive(true)
-			return resp, nil
+	// Состояние alive меняет только активная проверка, здесь результат запроса
+	// учитывается в outlier detection, чтобы одна ошибка не выкидывала бэкэнд из пула
+	start := time.Now()
+	transport := t.RoundTripper
+	if transport == nil {
+		transport = t.Pool.Transport()
+	}
+	resp, err := transport.RoundTrip(outReq)
+	if err != nil {
+		// Запрос отменен клиентом или балансировщиком, бэкэнд тут ни при чем
+		if ctx.Err() != nil {
+			b.Breaker.Release()
+			return nil, err
 		}
-		b.SetAlive(false)
-		log.Printf("Retry %d: %v", i+1, err)
-		time.Sleep(time.Second * time.Duration(i+1))
+		metrics.BackendRequests.Inc(backendURL, metrics.StatusClass(0))
+		b.Breaker.Record(false)
+		t.Pool.ReportResult(b, false)
+		log.Printf("Backend %s request failed: %v", backendURL, err)
+		return nil, err
+	}
+
+	elapsed := time.Since(start)
+	b.ObserveLatency(elapsed)
+	t.Pool.ObserveLatency(elapsed)
+	metrics.BackendLatency.Observe(elapsed.Seconds(), backendURL)
+	status := responseStatus(resp)
+	metrics.BackendRequests.Inc(backendURL, metrics.StatusClass(status))
+	ok := !backendFailed(resp)
+	b.Breaker.Record(ok)
+	t.Pool.ReportResult(b, ok)
+
+	return resp, nil
+}
+
+// Направляет запрос на бэкэнд так же, как httputil.ProxyRequest.SetURL: путь бэкэнда
+// (например, http://svc:8080/api) становится префиксом пути запроса, query объединяются
+func setTarget(u, target *url.URL) {
+	u.Scheme = target.Scheme
+	u.Host = target.Host
+	u.Path, u.RawPath = joinURLPath(target, u)
+	if target.RawQuery == "" || u.RawQuery == "" {
+		u.RawQuery = target.RawQuery + u.RawQuery
+	} else {
+		u.RawQuery = target.RawQuery + "&" + u.RawQuery
+	}
+}
+
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// Сверх бюджета повторов запрос не повторяется, а сразу завершается 503,
+// чтобы при массовых отказах не умножать нагрузку на оставшиеся бэкэнды
+func (t *CustomTransport) allowRetry() bool {
+	if t.Pool.RetryBudget().AllowRetry() {
+		return true
 	}
-	return resp, err
+	metrics.RetryBudgetExhausted.Inc(t.Pool.Name)
+	log.Printf("Pool %s: retry budget exhausted, failing fast", t.Pool.Name)
+	return false
 }
 
-func SetupProxyHandler(pool *backend.BackendPool) http.Handler {
+// Дочитывает и закрывает тело ответа, который не будет отдан клиенту,
+// чтобы соединение с бэкэндом вернулось в пул
+func drainBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+type ProxyOptions struct {
+	Sticky  *StickySessions
+	Retry   *RetryPolicy
+	Hedge   *HedgePolicy
+	Upgrade *UpgradePolicy
+}
+
+func SetupProxyHandler(pool *backend.BackendPool, opts ProxyOptions) http.Handler {
+	if opts.Retry == nil {
+		opts.Retry = NewRetryPolicy(config.RetryConfig{})
+	}
+	if opts.Upgrade == nil {
+		opts.Upgrade = NewUpgradePolicy(config.UpgradeConfig{})
+	}
+
 	proxy := &httputil.ReverseProxy{
+		// Бэкэнд выбирается в CustomTransport: повторный вызов NextBackend здесь
+		// сдвигал бы состояние стратегии (round robin, веса) на лишний шаг
 		Rewrite: func(r *httputil.ProxyRequest) {
-			b := pool.NextBackend()
-			if b == nil {
-				return
-			}
-			b.IncConn()
-			defer b.DecConn()
-
 			r.SetXForwarded()
-			r.SetURL(b.URL)
+			r.Out.Host = ""
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			log.Printf("Proxy error: %v", err)
+			if isGRPC(r.Header) {
+				grpcError(w, "Service unavailable")
+				return
+			}
 			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
 		},
 		Transport: &CustomTransport{
-			RoundTripper: http.DefaultTransport,
-			Retries:      numRetries,
-			Pool:         pool,
+			Pool:    pool,
+			Sticky:  opts.Sticky,
+			Retry:   opts.Retry,
+			Hedge:   opts.Hedge,
+			Upgrade: opts.Upgrade,
 		},
 	}
 
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRetryReplaysBodyOnUntriedBackends(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		maxBody    int64
+		healthy    string
+		wantStatus int
+		// Ожидаемое число попыток, каждая на своем бэкэнде
+		wantAttempts int
+	}{
+		{name: "third backend answers", method: http.MethodPut, body: "payload", healthy: "c.local", wantStatus: http.StatusOK, wantAttempts: 3},
+		{name: "first backend answers", method: http.MethodPut, body: "payload", healthy: "a.local", wantStatus: http.StatusOK, wantAttempts: 1},
+		{name: "all backends fail", method: http.MethodPut, body: "payload", wantStatus: http.StatusServiceUnavailable, wantAttempts: 3},
+		{name: "non idempotent method", method: http.MethodPost, body: "payload", wantStatus: http.StatusServiceUnavailable, wantAttempts: 1},
+		{name: "body over limit is streamed once", method: http.MethodPut, body: strings.Repeat("x", 100), maxBody: 10, wantStatus: http.StatusServiceUnavailable, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := backend.NewBackendPool([]config.BackendConfig{
+				{URL: "http://a.local", Weight: 1},
+				{URL: "http://b.local", Weight: 1},
+				{URL: "http://c.local", Weight: 1},
+			})
+			pool.Name = "test"
+			if err := pool.SetAlgorithm(backend.RoundRobinAlg, config.HashConfig{}); err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			var hosts []string
+			transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				body, err := io.ReadAll(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				if string(body) != tt.body {
+					t.Errorf("backend %s got body %q, want %q", req.URL.Host, body, tt.body)
+				}
+				mu.Lock()
+				hosts = append(hosts, req.URL.Host)
+				mu.Unlock()
+
+				status := http.StatusServiceUnavailable
+				if req.URL.Host == tt.healthy {
+					status = http.StatusOK
+				}
+				return &http.Response{StatusCode: status, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+			})
+
+			maxRetries := 5
+			ct := &CustomTransport{
+				RoundTripper: transport,
+				Pool:         pool,
+				Retry:        NewRetryPolicy(config.RetryConfig{MaxRetries: &maxRetries, MaxBodyBytes: tt.maxBody, BackoffBaseMs: 1, BackoffMaxMs: 1}),
+			}
+			// Первой выбирается a.local, чтобы порядок попыток был предсказуем
+			for pool.NextBackend(httptest.NewRequest("GET", "/", nil)).URL.Host != "c.local" {
+			}
+
+			req := httptest.NewRequest(tt.method, "http://lb.local/items", strings.NewReader(tt.body))
+			resp, err := ct.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if len(hosts) != tt.wantAttempts {
+				t.Fatalf("attempts %v, want %d", hosts, tt.wantAttempts)
+			}
+			seen := map[string]bool{}
+			for _, h := range hosts {
+				if seen[h] {
+					t.Errorf("backend %s tried twice: %v", h, hosts)
+				}
+				seen[h] = true
+			}
+			if hosts[0] != "a.local" {
+				t.Errorf("first attempt went to %s, want a.local", hosts[0])
+			}
+		})
+	}
+}
+
+func TestSetTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		req    string
+		want   string
+	}{
+		{target: "http://svc:8080", req: "/items?a=1", want: "http://svc:8080/items?a=1"},
+		{target: "http://svc:8080/api", req: "/items", want: "http://svc:8080/api/items"},
+		{target: "http://svc:8080/api/", req: "/items", want: "http://svc:8080/api/items"},
+		{target: "http://svc:8080/api?k=v", req: "/items?a=1", want: "http://svc:8080/api/items?k=v&a=1"},
+		{target: "http://svc:8080/a%2Fb", req: "/items", want: "http://svc:8080/a%2Fb/items"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target+tt.req, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.req, nil)
+			target, err := req.URL.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setTarget(req.URL, target)
+			if got := req.URL.String(); got != tt.want {
+				t.Errorf("url = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Отвечает редиректом на тот же адрес по HTTPS. Порт добавляется, если он не 443
+func RedirectHTTPS(tlsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tlsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(tlsPort))
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
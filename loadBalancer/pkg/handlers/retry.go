@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"loadBalancer/pkg/config"
+)
+
+const (
+	defaultMaxRetries    = 3
+	defaultMaxBodyBytes  = 64 * 1024
+	defaultBackoffBaseMs = 50
+	defaultBackoffMaxMs  = 1000
+)
+
+var (
+	// Идемпотентные методы по RFC 9110, повторять их безопасно
+	defaultRetryMethods     = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+	defaultRetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+)
+
+type RetryPolicy struct {
+	MaxRetries   int
+	MaxBodyBytes int64
+	BackoffBase  time.Duration
+	BackoffMax   time.Duration
+	methods      map[string]struct{}
+	statusCodes  map[int]struct{}
+	grpcServices []string
+}
+
+func NewRetryPolicy(cfg config.RetryConfig) *RetryPolicy {
+	p := &RetryPolicy{
+		MaxRetries:   defaultMaxRetries,
+		MaxBodyBytes: cfg.MaxBodyBytes,
+		BackoffBase:  time.Duration(cfg.BackoffBaseMs) * time.Millisecond,
+		BackoffMax:   time.Duration(cfg.BackoffMaxMs) * time.Millisecond,
+		methods:      make(map[string]struct{}),
+		statusCodes:  make(map[int]struct{}),
+		grpcServices: cfg.GRPCServices,
+	}
+	if cfg.MaxRetries != nil && *cfg.MaxRetries >= 0 {
+		p.MaxRetries = *cfg.MaxRetries
+	}
+	if p.MaxBodyBytes <= 0 {
+		p.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if p.BackoffBase <= 0 {
+		p.BackoffBase = defaultBackoffBaseMs * time.Millisecond
+	}
+	if p.BackoffMax <= 0 {
+		p.BackoffMax = defaultBackoffMaxMs * time.Millisecond
+	}
+
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = defaultRetryMethods
+	}
+	for _, m := range methods {
+		p.methods[m] = struct{}{}
+	}
+	statusCodes := cfg.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultRetryStatusCodes
+	}
+	for _, code := range statusCodes {
+		p.statusCodes[code] = struct{}{}
+	}
+
+	return p
+}
+
+func (p *RetryPolicy) MethodRetryable(method string) bool {
+	_, ok := p.methods[method]
+	return ok
+}
+
+// Можно ли повторять запрос: обычный - по методу, gRPC вызов - по списку grpc_services,
+// потому что все вызовы идут методом POST, а потоковые повторять нельзя
+func (p *RetryPolicy) RequestRetryable(req *http.Request) bool {
+	if !isGRPC(req.Header) {
+		return p.MethodRetryable(req.Method)
+	}
+	for _, prefix := range p.grpcServices {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Для gRPC код считается по grpc-status (см. responseStatus)
+func (p *RetryPolicy) StatusRetryable(code int) bool {
+	_, ok := p.statusCodes[code]
+	return ok
+}
+
+// Пауза перед повтором номер attempt (с 1): full jitter экспоненциального backoff
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.BackoffBase
+	for i := 1; i < attempt && d < p.BackoffMax; i++ {
+		d *= 2
+	}
+	if d > p.BackoffMax {
+		d = p.BackoffMax
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Буферизует тело запроса, чтобы отправить его заново (при повторе или в копии запроса).
+// Возвращает false, если тело больше limit: тогда запрос отправляется потоком
+func bufferBody(req *http.Request, limit int64) (bool, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return true, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(body)) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return false, nil
+	}
+
+	req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true, nil
+}
+
+// Читает тело запроса для копии (например, для зеркалирования) и подставляет его обратно.
+// GetBody не устанавливается, чтобы решение о повторах принималось по лимиту retry, как
+// без копии. Возвращает false, если тело больше limit: тогда запрос отправляется потоком
+func readBody(req *http.Request, limit int64) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+
+	src := req.Body
+	if req.GetBody != nil {
+		var err error
+		if src, err = req.GetBody(); err != nil {
+			return nil, false, err
+		}
+		defer src.Close()
+	}
+	body, err := io.ReadAll(io.LimitReader(src, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if req.GetBody != nil {
+		return body, int64(len(body)) <= limit, nil
+	}
+
+	rest := io.Reader(req.Body)
+	if int64(len(body)) <= limit {
+		// Тело прочитано целиком, оригинал остается только для Close
+		rest = http.NoBody
+	}
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), rest), req.Body}
+	return body, int64(len(body)) <= limit, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
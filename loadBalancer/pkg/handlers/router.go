@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/metrics"
+)
+
+// Таблица маршрутизации: запрос проксируется в пул первого подходящего маршрута,
+// а если ни один не подошел - в пул по умолчанию (404, если его нет)
+type Router struct {
+	routes   []*route
+	fallback http.Handler
+}
+
+type route struct {
+	name        string
+	pool        string
+	host        string
+	prefix      string
+	regex       *regexp.Regexp
+	methods     map[string]struct{}
+	headers     map[string]string
+	stripPrefix bool
+	rewrite     string
+	hedging     bool
+	handler     http.Handler
+	canary      *canary
+	mirror      *mirror
+}
+
+// Для каждого пула создается один прокси, который делят все маршруты этого пула
+func NewRouter(routes []config.RouteConfig, pools *backend.Pools, opts ProxyOptions) (*Router, error) {
+	proxies := make(map[string]http.Handler)
+	proxyFor := func(name string) http.Handler {
+		if h, ok := proxies[name]; ok {
+			return h
+		}
+		h := SetupProxyHandler(pools.Get(name), opts)
+		proxies[name] = h
+		return h
+	}
+
+	r := &Router{}
+	for i, rc := range routes {
+		if pools.Get(rc.Pool) == nil {
+			return nil, backend.ErrPoolNotFound
+		}
+
+		rt := &route{
+			name:        rc.RouteName(i),
+			pool:        rc.Pool,
+			host:        strings.ToLower(rc.Host),
+			prefix:      rc.PathPrefix,
+			headers:     rc.Headers,
+			stripPrefix: rc.StripPrefix,
+			rewrite:     rc.Rewrite,
+			hedging:     rc.Hedging,
+			handler:     proxyFor(rc.Pool),
+		}
+		if rc.Canary != nil {
+			if pools.Get(rc.Canary.Pool) == nil {
+				return nil, backend.ErrPoolNotFound
+			}
+			rt.canary = newCanary(rt.name, *rc.Canary, proxyFor(rc.Canary.Pool))
+		}
+		if rc.Mirror != nil {
+			pool := pools.Get(rc.Mirror.Pool)
+			if pool == nil {
+				return nil, backend.ErrPoolNotFound
+			}
+			rt.mirror = newMirror(*rc.Mirror, pool)
+		}
+		if rc.PathRegex != "" {
+			re, err := regexp.Compile(rc.PathRegex)
+			if err != nil {
+				return nil, err
+			}
+			rt.regex = re
+		}
+		if len(rc.Methods) > 0 {
+			rt.methods = make(map[string]struct{}, len(rc.Methods))
+			for _, m := range rc.Methods {
+				rt.methods[strings.ToUpper(m)] = struct{}{}
+			}
+		}
+		r.routes = append(r.routes, rt)
+	}
+
+	if pools.Get(config.DefaultPool) != nil {
+		r.fallback = proxyFor(config.DefaultPool)
+	}
+
+	return r, nil
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, rt := range r.routes {
+		if !rt.match(req) {
+			continue
+		}
+
+		handler, pool := rt.handler, rt.pool
+		if rt.canary != nil && rt.canary.pick(req) {
+			handler, pool = rt.canary.handler, rt.canary.pool
+		}
+		metrics.RouteRequests.Inc(rt.name, pool)
+
+		out := rt.rewriteRequest(req)
+		if rt.mirror != nil {
+			rt.mirror.send(out)
+		}
+		handler.ServeHTTP(w, out)
+		return
+	}
+
+	if r.fallback == nil {
+		http.Error(w, "No route", http.StatusNotFound)
+		return
+	}
+	r.fallback.ServeHTTP(w, req)
+}
+
+func (rt *route) match(req *http.Request) bool {
+	if rt.host != "" && !matchHost(rt.host, req.Host) {
+		return false
+	}
+	if rt.methods != nil {
+		if _, ok := rt.methods[req.Method]; !ok {
+			return false
+		}
+	}
+	for name, value := range rt.headers {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	if rt.prefix != "" && !matchPrefix(rt.prefix, req.URL.Path) {
+		return false
+	}
+	if rt.regex != nil && !rt.regex.MatchString(req.URL.Path) {
+		return false
+	}
+	return true
+}
+
+// Возвращает запрос с путем, измененным по правилам маршрута. Исходный запрос
+// не меняется, как и в http.StripPrefix
+func (rt *route) rewriteRequest(req *http.Request) *http.Request {
+	path := req.URL.Path
+	switch {
+	case rt.regex != nil && rt.rewrite != "":
+		path = rt.regex.ReplaceAllString(path, rt.rewrite)
+	case rt.prefix != "" && rt.rewrite != "":
+		path = strings.TrimSuffix(rt.rewrite, "/") + strings.TrimPrefix(path, strings.TrimSuffix(rt.prefix, "/"))
+	case rt.prefix != "" && rt.stripPrefix:
+		path = strings.TrimPrefix(path, strings.TrimSuffix(rt.prefix, "/"))
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	if path == req.URL.Path && !rt.hedging {
+		return req
+	}
+
+	r2 := new(http.Request)
+	*r2 = *req
+	u := *req.URL
+	u.Path = path
+	u.RawPath = ""
+	r2.URL = &u
+	if rt.hedging {
+		r2 = r2.WithContext(withHedging(r2.Context()))
+	}
+	return r2
+}
+
+// Префикс сравнивается по сегментам пути: /api подходит для /api и /api/users, но не для /apix
+func matchPrefix(prefix, path string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Host сравнивается без порта и без учета регистра, "*.example.com" подходит для поддоменов
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
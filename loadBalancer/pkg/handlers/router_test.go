@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+)
+
+// Ответ содержит имя маршрута и путь, с которым запрос ушел в пул
+func stubHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.Path)
+	})
+}
+
+func TestRouterMatchOrder(t *testing.T) {
+	pool := func() config.PoolConfig {
+		return config.PoolConfig{Backends: []config.BackendConfig{{URL: "http://127.0.0.1:1", Weight: 1}}}
+	}
+	cfg := &config.Config{
+		Algorithm: backend.RoundRobinAlg,
+		Backends:  []config.BackendConfig{{URL: "http://127.0.0.1:1", Weight: 1}},
+		Pools:     map[string]config.PoolConfig{"api": pool(), "web": pool(), "static": pool()},
+	}
+	pools, err := backend.NewPools(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := NewRouter([]config.RouteConfig{
+		{Name: "api-host", Host: "api.example.com", Pool: "api"},
+		{Name: "wildcard", Host: "*.cdn.example.com", Pool: "static"},
+		{Name: "static", PathPrefix: "/static", Methods: []string{"get"}, Pool: "static", StripPrefix: true},
+		{Name: "api-v2", PathPrefix: "/api", Headers: map[string]string{"X-Version": "2"}, Pool: "api", Rewrite: "/v2"},
+		{Name: "user", PathRegex: "^/users/([0-9]+)$", Pool: "web", Rewrite: "/profile/$1"},
+		{Name: "api", PathPrefix: "/api/", Pool: "web"},
+	}, pools, ProxyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rt := range router.routes {
+		rt.handler = stubHandler(rt.name)
+	}
+	router.fallback = stubHandler("default")
+
+	tests := []struct {
+		name   string
+		method string
+		host   string
+		path   string
+		header map[string]string
+		want   string
+	}{
+		{name: "host wins over later prefix", host: "api.example.com:8080", path: "/static/a.css", want: "api-host /static/a.css"},
+		{name: "host is case insensitive", host: "API.Example.com", path: "/", want: "api-host /"},
+		{name: "wildcard subdomain", host: "img.cdn.example.com", path: "/x", want: "wildcard /x"},
+		{name: "wildcard needs subdomain", host: "cdn.example.com", path: "/x", want: "default /x"},
+		{name: "prefix with strip", path: "/static/a.css", want: "static /a.css"},
+		{name: "method mismatch falls through", method: http.MethodPost, path: "/static/a.css", want: "default /static/a.css"},
+		{name: "header route before plain prefix", path: "/api/items", header: map[string]string{"X-Version": "2"}, want: "api-v2 /v2/items"},
+		{name: "header mismatch falls through", path: "/api/items", header: map[string]string{"X-Version": "1"}, want: "api /api/items"},
+		{name: "regex rewrite", path: "/users/42", want: "user /profile/42"},
+		{name: "regex no match", path: "/users/abc", want: "default /users/abc"},
+		{name: "prefix by segment", path: "/apix", want: "default /apix"},
+		{name: "prefix itself", path: "/api", want: "api /api"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
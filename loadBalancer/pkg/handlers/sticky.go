@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+)
+
+const defaultStickyCookie = "lb_backend"
+
+// Sticky-сессии: балансировщик выставляет cookie с непрозрачным идентификатором выбранного
+// бэкэнда (HMAC его URL), последующие запросы с cookie идут на тот же бэкэнд, пока он жив и
+// не в drain. Внутренние адреса бэкэндов клиенту не раскрываются, а бэкэнду cookie не передается
+type StickySessions struct {
+	CookieName string
+	MaxAge     int
+	secret     []byte
+}
+
+func NewStickySessions(cfg config.StickyConfig) *StickySessions {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	s := &StickySessions{
+		CookieName: cfg.CookieName,
+		MaxAge:     cfg.MaxAge,
+		secret:     []byte(cfg.Secret),
+	}
+	if s.CookieName == "" {
+		s.CookieName = defaultStickyCookie
+	}
+	if len(s.secret) == 0 {
+		s.secret = make([]byte, 32)
+		if _, err := rand.Read(s.secret); err != nil {
+			log.Fatalf("Не удалось сгенерировать секрет sticky-сессий: %v", err)
+		}
+		log.Println("sticky_session.secret не задан, cookie станут невалидны после перезапуска")
+	}
+	return s
+}
+
+// Возвращает бэкэнд из cookie запроса, если он есть в пуле и может принимать запросы
+func (s *StickySessions) Backend(pool *backend.BackendPool, req *http.Request) *backend.Backend {
+	cookie, err := req.Cookie(s.CookieName)
+	if err != nil {
+		return nil
+	}
+
+	b := pool.FindBackendFunc(func(b *backend.Backend) bool {
+		return hmac.Equal([]byte(cookie.Value), []byte(s.value(b)))
+	})
+	if b == nil || !b.IsAvailable() {
+		return nil
+	}
+	return b
+}
+
+// Убирает cookie балансировщика из запроса к бэкэнду, остальные cookie остаются как есть
+func (s *StickySessions) StripCookie(req *http.Request) {
+	values := req.Header.Values("Cookie")
+	if len(values) == 0 {
+		return
+	}
+
+	req.Header.Del("Cookie")
+	for _, line := range values {
+		parts := strings.Split(line, ";")
+		kept := parts[:0]
+		for _, part := range parts {
+			name, _, _ := strings.Cut(part, "=")
+			if strings.TrimSpace(name) != s.CookieName {
+				kept = append(kept, part)
+			}
+		}
+		if len(kept) > 0 {
+			req.Header.Add("Cookie", strings.TrimSpace(strings.Join(kept, ";")))
+		}
+	}
+}
+
+// Выставляет cookie на ответ, если клиент пришел без нее или был перенаправлен на другой бэкэнд
+func (s *StickySessions) SetCookie(resp *http.Response, req *http.Request, b *backend.Backend) {
+	value := s.value(b)
+	if cookie, err := req.Cookie(s.CookieName); err == nil && cookie.Value == value {
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     s.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   s.MaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	resp.Header.Add("Set-Cookie", cookie.String())
+}
+
+// Идентификатор считается заново при каждом обращении и нигде не хранится, поэтому после
+// удаления бэкэнда из пула (admin API или перечитывание конфига) от него ничего не остается
+func (s *StickySessions) value(b *backend.Backend) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(b.URL.String()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+)
+
+func TestStickySessions(t *testing.T) {
+	s := NewStickySessions(config.StickyConfig{Enabled: true, Secret: "secret"})
+	pool := backend.NewBackendPool([]config.BackendConfig{
+		{URL: "http://a.local", Weight: 1},
+		{URL: "http://b.local", Weight: 1},
+	})
+	a, b := pool.Backends[0], pool.Backends[1]
+
+	// Ответ для клиента без cookie выставляет cookie выбранного бэкэнда
+	resp := &http.Response{Header: http.Header{}}
+	s.SetCookie(resp, httptest.NewRequest("GET", "/", nil), b)
+	cookies := (&http.Response{Header: resp.Header}).Cookies()
+	if len(cookies) != 1 || cookies[0].Name != defaultStickyCookie {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	cookie := cookies[0]
+	if strings.Contains(cookie.Value, "b.local") || !cookie.HttpOnly {
+		t.Errorf("cookie = %s, want opaque http only value", cookie)
+	}
+
+	withCookie := func() *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Cookie", "session=1; "+cookie.Name+"="+cookie.Value+"; theme=dark")
+		return req
+	}
+
+	if got := s.Backend(pool, withCookie()); got != b {
+		t.Fatalf("backend = %v, want b.local", got)
+	}
+	// Клиент уже привязан к бэкэнду, cookie не выставляется заново
+	resp = &http.Response{Header: http.Header{}}
+	s.SetCookie(resp, withCookie(), b)
+	if len(resp.Header.Values("Set-Cookie")) != 0 {
+		t.Error("cookie set again for the same backend")
+	}
+	// Клиента перенаправили на другой бэкэнд
+	s.SetCookie(resp, withCookie(), a)
+	if len(resp.Header.Values("Set-Cookie")) != 1 {
+		t.Error("cookie not updated after switching backend")
+	}
+
+	// Другой секрет - cookie недействительна
+	other := NewStickySessions(config.StickyConfig{Enabled: true, Secret: "other"})
+	if got := other.Backend(pool, withCookie()); got != nil {
+		t.Errorf("cookie accepted with another secret: %s", got.URL)
+	}
+
+	b.SetDraining(true)
+	if got := s.Backend(pool, withCookie()); got != nil {
+		t.Errorf("draining backend returned: %s", got.URL)
+	}
+	b.SetDraining(false)
+
+	// Идентификатор зависит только от URL: удаленный бэкэнд не находится, а новый бэкэнд
+	// с тем же URL находится по той же cookie
+	if err := pool.RemoveBackend("http://b.local"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Backend(pool, withCookie()); got != nil {
+		t.Errorf("removed backend returned: %s", got.URL)
+	}
+	readded := backend.NewBackendPool([]config.BackendConfig{{URL: "http://b.local", Weight: 1}})
+	if got := s.Backend(readded, withCookie()); got != readded.Backends[0] {
+		t.Errorf("backend with the same url = %v, want b.local", got)
+	}
+
+	// Cookie балансировщика не уходит на бэкэнд, остальные остаются
+	req := withCookie()
+	s.StripCookie(req)
+	if got := req.Header.Get("Cookie"); got != "session=1; theme=dark" {
+		t.Errorf("Cookie = %q", got)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/metrics"
+)
+
+var ErrUpgradeLimit = errors.New("upgraded connections limit reached")
+
+type UpgradePolicy struct {
+	IdleTimeout   time.Duration
+	MaxPerBackend int
+}
+
+func NewUpgradePolicy(cfg config.UpgradeConfig) *UpgradePolicy {
+	cfg = cfg.WithDefaults()
+	return &UpgradePolicy{
+		IdleTimeout:   time.Duration(cfg.IdleTimeoutMs) * time.Millisecond,
+		MaxPerBackend: cfg.MaxPerBackend,
+	}
+}
+
+// Запрос на смену протокола (WebSocket и т.п.): Connection содержит upgrade и задан Upgrade
+func isUpgrade(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Резервирует место под upgraded соединение на бэкэнде b
+func (p *UpgradePolicy) acquire(b *backend.Backend) bool {
+	if b.AcquireUpgrade(p.MaxPerBackend) {
+		return true
+	}
+	metrics.UpgradeRejected.Inc(b.URL.String())
+	log.Printf("Backend %s: %d upgraded connections, limit reached", b.URL, p.MaxPerBackend)
+	return false
+}
+
+// Результат попытки upgrade запроса: при ответе 101 тело ответа заменяется на учитываемое
+// соединение, иначе зарезервированное место освобождается
+func (p *UpgradePolicy) track(resp *http.Response, err error, b *backend.Backend) *http.Response {
+	if err == nil && resp.StatusCode == http.StatusSwitchingProtocols {
+		if rwc, ok := resp.Body.(io.ReadWriteCloser); ok {
+			resp.Body = p.wrap(rwc, b)
+			return resp
+		}
+	}
+	b.ReleaseUpgrade()
+	return resp
+}
+
+// Соединение с бэкэндом после ответа 101. ReverseProxy копирует данные между ним и клиентом
+// и закрывает оба соединения, когда одна из сторон закрылась, поэтому соединение учитывается
+// в ActiveConn до Close, а не до получения заголовков ответа, как обычный запрос
+type upgradedConn struct {
+	io.ReadWriteCloser
+	backend    *backend.Backend
+	timeout    time.Duration
+	lastActive atomic.Int64
+	timer      *time.Timer
+	once       sync.Once
+}
+
+// Оборачивает тело ответа 101. Место под соединение должно быть зарезервировано (AcquireUpgrade)
+func (p *UpgradePolicy) wrap(rwc io.ReadWriteCloser, b *backend.Backend) *upgradedConn {
+	c := &upgradedConn{
+		ReadWriteCloser: rwc,
+		backend:         b,
+		timeout:         p.IdleTimeout,
+	}
+	c.touch()
+	b.IncConn()
+	// Таймер запускается после регистрации, чтобы Close из checkIdle не опередил TrackUpgraded
+	c.timer = time.AfterFunc(time.Duration(math.MaxInt64), c.checkIdle)
+	b.TrackUpgraded(c)
+	c.timer.Reset(c.timeout)
+	return c
+}
+
+func (c *upgradedConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// Таймер не переставляется на каждый Read/Write: при срабатывании он проверяет время
+// последней активности и либо закрывает соединение, либо ждет оставшееся время
+func (c *upgradedConn) checkIdle() {
+	idle := time.Since(time.Unix(0, c.lastActive.Load()))
+	if idle < c.timeout {
+		c.timer.Reset(c.timeout - idle)
+		return
+	}
+	log.Printf("Upgraded connection to %s idle for %s, closing", c.backend.URL, idle.Round(time.Second))
+	metrics.UpgradeClosed.Inc(c.backend.URL.String(), "idle")
+	c.Close()
+}
+
+func (c *upgradedConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+// Закрывается из ReverseProxy, по таймауту или при drain бэкэнда, учет снимается один раз
+func (c *upgradedConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.timer.Stop()
+		err = c.ReadWriteCloser.Close()
+		c.backend.UntrackUpgraded(c)
+		c.backend.DecConn()
+	})
+	return err
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+)
+
+// Соединение с бэкэндом после 101, запоминает закрытие
+type fakeConn struct {
+	closed atomic.Bool
+}
+
+func (c *fakeConn) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (c *fakeConn) Write(p []byte) (int, error) { return len(p), nil }
+func (c *fakeConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func switchingProtocols(conn io.ReadWriteCloser) *http.Response {
+	return &http.Response{StatusCode: http.StatusSwitchingProtocols, Header: http.Header{}, Body: conn}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string][]string
+		want   bool
+	}{
+		{name: "websocket", header: map[string][]string{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}}, want: true},
+		{name: "token list", header: map[string][]string{"Connection": {"keep-alive, upgrade"}, "Upgrade": {"websocket"}}, want: true},
+		{name: "several connection headers", header: map[string][]string{"Connection": {"keep-alive", "Upgrade"}, "Upgrade": {"h2c"}}, want: true},
+		{name: "no upgrade header", header: map[string][]string{"Connection": {"Upgrade"}}},
+		{name: "no connection token", header: map[string][]string{"Connection": {"keep-alive"}, "Upgrade": {"websocket"}}},
+		{name: "plain request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, values := range tt.header {
+				for _, v := range values {
+					req.Header.Add(k, v)
+				}
+			}
+			if got := isUpgrade(req); got != tt.want {
+				t.Errorf("isUpgrade = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeLimitPerBackend(t *testing.T) {
+	b := backend.NewBackendPool([]config.BackendConfig{{URL: "http://a.local", Weight: 1}}).Backends[0]
+	p := NewUpgradePolicy(config.UpgradeConfig{MaxPerBackend: 2})
+
+	if !p.acquire(b) || !p.acquire(b) {
+		t.Fatal("acquire within limit failed")
+	}
+	if p.acquire(b) {
+		t.Fatal("acquire over limit succeeded")
+	}
+
+	// Бэкэнд не согласился на upgrade: место освобождается сразу
+	p.track(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil, b)
+	p.track(nil, errors.New("dial failed"), b)
+	if got := b.UpgradedCount(); got != 0 {
+		t.Fatalf("upgraded = %d after failed upgrades, want 0", got)
+	}
+
+	// Установленное соединение занимает место и учитывается в ActiveConn до закрытия
+	for i := 0; i < 2; i++ {
+		if !p.acquire(b) {
+			t.Fatal("acquire after release failed")
+		}
+	}
+	first := p.track(switchingProtocols(&fakeConn{}), nil, b)
+	p.track(switchingProtocols(&fakeConn{}), nil, b)
+	if p.acquire(b) {
+		t.Fatal("acquire over limit succeeded with open connections")
+	}
+	if got := b.ConnCount(); got != 2 {
+		t.Errorf("active conns = %d, want 2", got)
+	}
+
+	first.Body.Close()
+	first.Body.Close()
+	if got := b.ConnCount(); got != 1 {
+		t.Errorf("active conns after close = %d, want 1", got)
+	}
+	if !p.acquire(b) {
+		t.Error("acquire after close failed")
+	}
+}
+
+func TestUpgradedConnClosedOnDrain(t *testing.T) {
+	b := backend.NewBackendPool([]config.BackendConfig{{URL: "http://a.local", Weight: 1}}).Backends[0]
+	p := NewUpgradePolicy(config.UpgradeConfig{})
+
+	conns := []*fakeConn{{}, {}}
+	for _, c := range conns {
+		p.acquire(b)
+		p.track(switchingProtocols(c), nil, b)
+	}
+
+	b.SetDraining(true)
+	for i, c := range conns {
+		if !c.closed.Load() {
+			t.Errorf("connection %d not closed on drain", i)
+		}
+	}
+	if b.UpgradedCount() != 0 || b.ConnCount() != 0 {
+		t.Errorf("upgraded = %d, active conns = %d after drain, want 0", b.UpgradedCount(), b.ConnCount())
+	}
+}
+
+func TestUpgradedConnIdleTimeout(t *testing.T) {
+	b := backend.NewBackendPool([]config.BackendConfig{{URL: "http://a.local", Weight: 1}}).Backends[0]
+	p := &UpgradePolicy{IdleTimeout: 50 * time.Millisecond}
+
+	conn := &fakeConn{}
+	p.acquire(b)
+	resp := p.track(switchingProtocols(conn), nil, b)
+
+	// Пока по соединению идут данные, оно не закрывается
+	for i := 0; i < 6; i++ {
+		time.Sleep(20 * time.Millisecond)
+		resp.Body.(io.Writer).Write([]byte("ping"))
+	}
+	if conn.closed.Load() {
+		t.Fatal("active connection closed")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if !conn.closed.Load() {
+		t.Fatal("idle connection not closed")
+	}
+	if b.UpgradedCount() != 0 || b.ConnCount() != 0 {
+		t.Errorf("upgraded = %d, active conns = %d after idle close, want 0", b.UpgradedCount(), b.ConnCount())
+	}
+}
+
+// Бэкэнд с максимумом upgraded соединений пропускается, запрос уходит на следующий
+func TestRoundTripSkipsFullBackend(t *testing.T) {
+	pool := backend.NewBackendPool([]config.BackendConfig{
+		{URL: "http://a.local", Weight: 1},
+		{URL: "http://b.local", Weight: 1},
+	})
+	pool.Name = "test"
+	if err := pool.SetAlgorithm(backend.RoundRobinAlg, config.HashConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	policy := NewUpgradePolicy(config.UpgradeConfig{MaxPerBackend: 1})
+
+	var hosts []string
+	ct := &CustomTransport{
+		RoundTripper: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			hosts = append(hosts, req.URL.Host)
+			return switchingProtocols(&fakeConn{}), nil
+		}),
+		Pool:    pool,
+		Retry:   NewRetryPolicy(config.RetryConfig{}),
+		Upgrade: policy,
+	}
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://lb.local/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		return req
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ct.RoundTrip(newRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(hosts) != 2 || hosts[0] == hosts[1] {
+		t.Fatalf("upgrades went to %v, want one per backend", hosts)
+	}
+
+	if _, err := ct.RoundTrip(newRequest()); !errors.Is(err, ErrUpgradeLimit) {
+		t.Errorf("err = %v, want %v", err, ErrUpgradeLimit)
+	}
+	if len(hosts) != 2 {
+		t.Errorf("request sent to a full backend: %v", hosts)
+	}
+}
@@ -0,0 +1,299 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Минимальная реализация метрик в текстовом формате Prometheus, чтобы не тянуть
+// внешние зависимости: счетчики, gauge и гистограммы с метками, а также gauge,
+// значения которых снимаются в момент запроса /metrics
+
+type Collector interface {
+	Write(w io.Writer)
+}
+
+type Registry struct {
+	collectors []Collector
+	mu         sync.RWMutex
+}
+
+var Default = &Registry{}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		r.mu.RLock()
+		for _, c := range r.collectors {
+			c.Write(bw)
+		}
+		r.mu.RUnlock()
+		bw.Flush()
+	})
+}
+
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	series map[string]*series
+	mu     sync.Mutex
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{name: name, help: help, labels: labels, series: make(map[string]*series)}
+}
+
+func (v *vec) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) write(w io.Writer, typ string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	writeHeader(w, v.name, v.help, typ)
+	for _, s := range sortedSeries(v.series) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+type CounterVec struct {
+	vec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, labels)}
+	Default.Register(c)
+	return c
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	c.get(labelValues).value += delta
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Write(w io.Writer) {
+	c.write(w, "counter")
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, labels)}
+	Default.Register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	g.get(labelValues).value = value
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.mu.Lock()
+	delete(g.series, strings.Join(labelValues, "\xff"))
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) Write(w io.Writer) {
+	g.write(w, "gauge")
+}
+
+// Gauge, значения которого собираются функцией collect в момент запроса /metrics
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func(set func(value float64, labelValues ...string))
+}
+
+func NewGaugeFunc(name, help string, labels []string, collect func(set func(value float64, labelValues ...string))) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+	Default.Register(g)
+	return g
+}
+
+func (g *GaugeFunc) Write(w io.Writer) {
+	values := make(map[string]*series)
+	g.collect(func(value float64, labelValues ...string) {
+		values[strings.Join(labelValues, "\xff")] = &series{labelValues: labelValues, value: value}
+	})
+
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, s := range sortedSeries(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries
+	mu      sync.Mutex
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	Default.Register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, k := range keys {
+		s := h.series[k]
+		for i, upper := range h.buckets {
+			lv := append(append([]string(nil), s.labelValues...), formatValue(upper))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, lv), s.counts[i])
+		}
+		lv := append(append([]string(nil), s.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, lv), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues), s.count)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func sortedSeries(m map[string]*series) []*series {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]*series, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, m[k])
+	}
+	return result
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+var (
+	BackendRequests = NewCounterVec("lb_backend_requests_total",
+		"Requests proxied to backend by response status class", "backend", "code")
+	BackendLatency = NewHistogramVec("lb_backend_request_duration_seconds",
+		"Backend response time until headers are received", DefBuckets, "backend")
+	Retries = NewCounterVec("lb_retries_total",
+		"Retries made by the proxy transport after a failed attempt on backend", "backend")
+	RetryBudgetExhausted = NewCounterVec("lb_retry_budget_exhausted_total",
+		"Retries denied because the pool-wide retry budget was exhausted", "pool")
+	HedgedRequests = NewCounterVec("lb_hedged_requests_total",
+		"Hedged copies of slow requests sent to backend", "backend")
+	HedgeWins = NewCounterVec("lb_hedge_wins_total",
+		"Hedged copies that answered before the original request", "backend")
+	RouteRequests = NewCounterVec("lb_route_requests_total",
+		"Requests matched by route and the pool they were sent to (main or canary)", "route", "pool")
+	MirrorRequests = NewCounterVec("lb_mirror_requests_total",
+		"Shadow requests sent to mirror pool by response status class", "pool", "backend", "code")
+	MirrorLatency = NewHistogramVec("lb_mirror_request_duration_seconds",
+		"Shadow request time until headers are received", DefBuckets, "pool")
+	MirrorDropped = NewCounterVec("lb_mirror_dropped_total",
+		"Sampled requests that were not mirrored", "pool", "reason")
+	UpgradeRejected = NewCounterVec("lb_upgrade_rejected_total",
+		"Upgrade requests not sent to backend because of max_per_backend limit", "backend")
+	UpgradeClosed = NewCounterVec("lb_upgrade_closed_total",
+		"Upgraded connections closed by the balancer (idle timeout or backend drain)", "backend", "reason")
+	TCPConnections = NewCounterVec("lb_tcp_connections_total",
+		"Connections accepted by TCP listener and proxied to backend", "listener", "backend")
+	TCPRejected = NewCounterVec("lb_tcp_rejected_total",
+		"TCP connections refused or skipped backends by reason", "listener", "reason")
+	TCPIdleClosed = NewCounterVec("lb_tcp_idle_closed_total",
+		"TCP connections closed after idle_timeout_ms without traffic", "listener")
+	TCPBytes = NewCounterVec("lb_tcp_bytes_total",
+		"Bytes copied by TCP listener: sent to backends and received from them", "listener", "direction")
+	HealthChecks = NewCounterVec("lb_health_checks_total",
+		"Active health check results", "backend", "result")
+)
+
+// Класс ответа для метки code: 2xx, 3xx, 4xx, 5xx или error для ошибок транспорта
+func StatusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "error"
+	}
+	return string(rune('0'+code/100)) + "xx"
+}
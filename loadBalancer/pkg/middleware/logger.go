@@ -1,16 +1,124 @@
 package middleware
 
 import (
-	"log"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 )
 
+const RequestIDHeader = "X-Request-ID"
+
+// Максимальная длина X-Request-ID, который принимаем от клиента
+const maxRequestIDLen = 128
+
+type accessInfoKey struct{}
+
+// Сведения о проксировании, которые заполняет транспорт и выводит access log
+type AccessInfo struct {
+	pool    string
+	backend string
+	retries int
+	mu      sync.Mutex
+}
+
+func (a *AccessInfo) SetPool(pool string) {
+	a.mu.Lock()
+	a.pool = pool
+	a.mu.Unlock()
+}
+
+func (a *AccessInfo) SetBackend(backend string) {
+	a.mu.Lock()
+	a.backend = backend
+	a.mu.Unlock()
+}
+
+func (a *AccessInfo) IncRetries() {
+	a.mu.Lock()
+	a.retries++
+	a.mu.Unlock()
+}
+
+func (a *AccessInfo) get() (string, string, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pool, a.backend, a.retries
+}
+
+// Возвращает AccessInfo запроса, nil если запрос пришел не через LoggingMiddleware
+func GetAccessInfo(ctx context.Context) *AccessInfo {
+	info, _ := ctx.Value(accessInfoKey{}).(*AccessInfo)
+	return info
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Нужен http.ResponseController, чтобы Flush и Hijack доходили до исходного ResponseWriter
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Структурированный access log. X-Request-ID берется из запроса или генерируется,
+// передается бэкэндам и возвращается клиенту
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		log.Printf("-> %s %s", r.Method, r.URL)
-		next.ServeHTTP(w, r)
-		log.Printf("<- %s %s in %v", r.Method, r.URL, time.Since(start))
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLen {
+			requestID = newRequestID()
+		}
+		r.Header.Set(RequestIDHeader, requestID)
+		w.Header().Set(RequestIDHeader, requestID)
+
+		info := &AccessInfo{}
+		r = r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info))
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		pool, backend, retries := info.get()
+		slog.Info("access",
+			slog.String("request_id", requestID),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.RequestURI()),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.Int("status", sw.status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start))/float64(time.Millisecond)),
+			slog.String("pool", pool),
+			slog.String("backend", backend),
+			slog.Int("retries", retries),
+		)
 	})
 }
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,319 @@
+// PROXY protocol v1/v2: разбор заголовка на входящих соединениях и отправка на бэкэнды.
+// Разбор заголовка скопирован в rateLimiting/pkg/proxyproto, исправления нужно вносить в обе копии
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidHeader      = errors.New("invalid proxy protocol header")
+	ErrUnsupportedVersion = errors.New("unsupported proxy protocol version")
+)
+
+// Сигнатура заголовка версии 2
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Максимальная длина заголовка версии 1 по спецификации, включая CRLF
+const v1MaxLength = 107
+
+// Listener, который читает заголовок PROXY protocol (v1 или v2) у соединений от доверенных
+// адресов, и подставляет адрес клиента из заголовка в RemoteAddr. Заголовок читается при первом
+// обращении к соединению, а не в Accept, чтобы медленный клиент не задерживал прием остальных.
+// У соединений с других адресов заголовок не разбирается: иначе любой клиент мог бы подменить
+// свой адрес
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func NewListener(ln net.Listener, trusted []*net.IPNet, headerTimeout time.Duration) *Listener {
+	return &Listener{Listener: ln, trusted: trusted, timeout: headerTimeout}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, timeout: l.timeout}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Разбирает список CIDR, отдельный IP считается сетью из одного адреса
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Соединение от доверенного адреса. Если заголовка нет, соединение работает как обычное,
+// если заголовок некорректен - чтение возвращает ошибку
+type Conn struct {
+	net.Conn
+	timeout time.Duration
+	once    sync.Once
+	reader  *bufio.Reader
+	src     net.Addr
+	dst     net.Addr
+	err     error
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.src, c.dst, c.err = readHeader(c.reader)
+		if c.err != nil {
+			log.Printf("PROXY protocol header from %s: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// Адрес клиента из заголовка, без заголовка - адрес соединения
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// Адрес, на который клиент подключался к первому прокси
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Нужен для half-close при проксировании TCP
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// Читает заголовок, если он есть. Версия определяется по первому байту, чтобы не ждать
+// лишних данных от клиента, который заголовок не прислал
+func readHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		// Клиент закрыл соединение, ничего не прислав: ошибку вернет следующее чтение
+		if err == io.EOF {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	switch first[0] {
+	case 'P':
+		prefix, err := r.Peek(6)
+		if err != nil || string(prefix) != "PROXY " {
+			return nil, nil, nil
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		sig, err := r.Peek(len(v2Signature))
+		if err != nil || !bytes.Equal(sig, v2Signature) {
+			return nil, nil, nil
+		}
+		return readV2(r)
+	}
+	return nil, nil, nil
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: v1 header too long or not terminated", ErrInvalidHeader)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	p, err := strconv.Atoi(port)
+	if addr == nil || err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("%w: address %s:%s", ErrInvalidHeader, ip, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: p}, nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: v2 version %d", ErrUnsupportedVersion, header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL - соединение самого прокси (например, проверка), адрес не меняется
+	if command == 0 {
+		return nil, nil, nil
+	}
+	if command != 1 {
+		return nil, nil, fmt.Errorf("%w: v2 command %d", ErrInvalidHeader, command)
+	}
+
+	// Поддерживаются только TCP over IPv4/IPv6, для остальных адрес остается прежним.
+	// TLV после адресов пропускаются
+	var ipLen int
+	switch family {
+	case 0x11:
+		ipLen = net.IPv4len
+	case 0x21:
+		ipLen = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("%w: v2 address block too short", ErrInvalidHeader)
+	}
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return src, dst, nil
+}
+
+// Пишет заголовок версии version (1 или 2) с адресами src и dst. Если адреса не TCP,
+// пишется заголовок без адресов (UNKNOWN для v1, LOCAL для v2)
+func WriteHeader(w io.Writer, version int, src, dst net.Addr) error {
+	srcTCP, ok1 := src.(*net.TCPAddr)
+	dstTCP, ok2 := dst.(*net.TCPAddr)
+	known := ok1 && ok2
+	ipv4 := known && srcTCP.IP.To4() != nil && dstTCP.IP.To4() != nil
+
+	switch version {
+	case 1:
+		if !known {
+			_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+			return err
+		}
+		proto, srcIP, dstIP := "TCP6", srcTCP.IP.To16().String(), dstTCP.IP.To16().String()
+		if ipv4 {
+			proto, srcIP, dstIP = "TCP4", srcTCP.IP.To4().String(), dstTCP.IP.To4().String()
+		}
+		_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, srcTCP.Port, dstTCP.Port)
+		return err
+	case 2:
+		header := append([]byte{}, v2Signature...)
+		if !known {
+			header = append(header, 0x20, 0x00, 0, 0)
+			_, err := w.Write(header)
+			return err
+		}
+		family, srcIP, dstIP := byte(0x21), srcTCP.IP.To16(), dstTCP.IP.To16()
+		if ipv4 {
+			family, srcIP, dstIP = 0x11, srcTCP.IP.To4(), dstTCP.IP.To4()
+		}
+		header = append(header, 0x21, family)
+		header = binary.BigEndian.AppendUint16(header, uint16(2*len(srcIP)+4))
+		header = append(header, srcIP...)
+		header = append(header, dstIP...)
+		header = binary.BigEndian.AppendUint16(header, uint16(srcTCP.Port))
+		header = binary.BigEndian.AppendUint16(header, uint16(dstTCP.Port))
+		_, err := w.Write(header)
+		return err
+	}
+	return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+}
@@ -0,0 +1,112 @@
+package tcpproxy
+
+import (
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"loadBalancer/pkg/metrics"
+)
+
+// Пара соединений клиент - бэкэнд. Когда одна сторона закончила передачу (EOF), другой
+// стороне закрывается запись, а обратное направление продолжает работать: так протоколы
+// с half-close не обрываются раньше времени. При ошибке закрываются оба соединения
+type session struct {
+	listener   string
+	client     net.Conn
+	upstream   net.Conn
+	timeout    time.Duration
+	lastActive atomic.Int64
+	once       sync.Once
+}
+
+func newSession(listener string, client, upstream net.Conn, idleTimeout time.Duration) *session {
+	s := &session{listener: listener, client: client, upstream: upstream, timeout: idleTimeout}
+	s.touch()
+	return s
+}
+
+func (s *session) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// Копирует данные, пока обе стороны не закончили передачу. Возвращает число байт,
+// отправленных бэкэнду и полученных от него
+func (s *session) run() (sent, received int64) {
+	// Таймер не переставляется на каждую передачу: при срабатывании он проверяет время
+	// последней активности и либо закрывает соединения, либо ждет оставшееся время
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Duration(math.MaxInt64), func() {
+		idle := s.idle()
+		if idle < s.timeout {
+			timer.Reset(s.timeout - idle)
+			return
+		}
+		log.Printf("TCP %s: connection from %s idle for %s, closing", s.listener, s.client.RemoteAddr(), idle.Round(time.Second))
+		metrics.TCPIdleClosed.Inc(s.listener)
+		s.close()
+	})
+	timer.Reset(s.timeout)
+	defer timer.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sent = s.copy(s.upstream, s.client)
+	}()
+	received = s.copy(s.client, s.upstream)
+	wg.Wait()
+
+	s.close()
+	return sent, received
+}
+
+func (s *session) copy(dst, src net.Conn) int64 {
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		nr, err := src.Read(buf)
+		if nr > 0 {
+			s.touch()
+			nw, werr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if werr != nil {
+				s.close()
+				return written
+			}
+		}
+		if err == io.EOF {
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+				return written
+			}
+		}
+		if err != nil {
+			s.close()
+			return written
+		}
+	}
+}
+
+// Сколько по соединению ничего не передавалось
+func (s *session) idle() time.Duration {
+	return time.Since(time.Unix(0, s.lastActive.Load()))
+}
+
+// Нужен, чтобы бэкэнд мог закрыть соединение при drain или удалении
+func (s *session) Close() error {
+	s.close()
+	return nil
+}
+
+func (s *session) close() {
+	s.once.Do(func() {
+		s.client.Close()
+		s.upstream.Close()
+	})
+}
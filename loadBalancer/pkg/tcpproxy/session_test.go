@@ -0,0 +1,126 @@
+package tcpproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Два соединенных TCP соединения на loopback
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+	return dialed.(*net.TCPConn), conn.(*net.TCPConn)
+}
+
+func TestSessionHalfClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// Сторона, которая первой заканчивает передачу: после ее EOF другая сторона
+		// должна суметь отправить ответ
+		first string
+	}{
+		{name: "client closes write first", first: "client"},
+		{name: "backend closes write first", first: "backend"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, sessionClient := tcpPair(t)
+			sessionUpstream, upstream := tcpPair(t)
+
+			s := newSession("test", sessionClient, sessionUpstream, time.Minute)
+			type result struct{ sent, received int64 }
+			done := make(chan result, 1)
+			go func() {
+				sent, received := s.run()
+				done <- result{sent, received}
+			}()
+
+			first, second := client, upstream
+			if tt.first == "backend" {
+				first, second = upstream, client
+			}
+
+			if _, err := first.Write([]byte("request")); err != nil {
+				t.Fatal(err)
+			}
+			first.CloseWrite()
+
+			// Вторая сторона получает данные и EOF, но ее запись еще открыта
+			second.SetReadDeadline(time.Now().Add(5 * time.Second))
+			got, err := io.ReadAll(second)
+			if err != nil || string(got) != "request" {
+				t.Fatalf("read %q, %v; want request", got, err)
+			}
+			if _, err := second.Write([]byte("response!")); err != nil {
+				t.Fatal(err)
+			}
+			second.CloseWrite()
+
+			first.SetReadDeadline(time.Now().Add(5 * time.Second))
+			got, err = io.ReadAll(first)
+			if err != nil || string(got) != "response!" {
+				t.Fatalf("read %q, %v; want response!", got, err)
+			}
+
+			select {
+			case r := <-done:
+				wantSent, wantReceived := int64(len("request")), int64(len("response!"))
+				if tt.first == "backend" {
+					wantSent, wantReceived = wantReceived, wantSent
+				}
+				if r.sent != wantSent || r.received != wantReceived {
+					t.Errorf("sent %d, received %d; want %d, %d", r.sent, r.received, wantSent, wantReceived)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("session did not finish after both sides closed")
+			}
+		})
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	client, sessionClient := tcpPair(t)
+	sessionUpstream, _ := tcpPair(t)
+
+	s := newSession("test", sessionClient, sessionUpstream, 100*time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle session was not closed")
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("client connection is still open")
+	}
+}
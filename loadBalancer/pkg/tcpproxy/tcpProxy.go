@@ -0,0 +1,317 @@
+package tcpproxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"loadBalancer/pkg/backend"
+	"loadBalancer/pkg/config"
+	"loadBalancer/pkg/metrics"
+	"loadBalancer/pkg/proxyproto"
+)
+
+var (
+	ErrProxyClosed         = errors.New("tcp proxy closed")
+	ErrNoAvailableBackends = errors.New("no available backends")
+	ErrCircuitOpen         = errors.New("circuit breaker is open")
+	ErrBackendLimit        = errors.New("backend connections limit reached")
+)
+
+// Сколько бэкэндов пробуем, если соединение не устанавливается
+const maxConnectAttempts = 3
+
+// При Shutdown соединения, по которым ничего не передавалось shutdownIdle, закрываются
+// сразу: в L4 нет границ запросов, поэтому простой - единственный признак, что обрыв безопасен
+const (
+	shutdownIdle         = time.Second
+	shutdownPollInterval = 500 * time.Millisecond
+)
+
+// L4 прокси: для каждого входящего соединения стратегия пула выбирает бэкэнд, после чего
+// байты копируются в обе стороны без разбора протокола. Соединение учитывается в ActiveConn
+// бэкэнда все время своей жизни, поэтому least_conn и p2c работают так же, как для HTTP
+type Proxy struct {
+	name           string
+	addr           string
+	pool           *backend.BackendPool
+	connectTimeout time.Duration
+	idleTimeout    time.Duration
+	maxPerBackend  int64
+	// Версия PROXY protocol для бэкэндов, 0 - не отправлять
+	sendProxy int
+	// Свободные места под соединения, nil - без ограничения
+	slots chan struct{}
+
+	mu       sync.Mutex
+	listener net.Listener
+	sessions map[*session]struct{}
+	closing  bool
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func NewProxy(cfg config.TCPListenerConfig, pool *backend.BackendPool) *Proxy {
+	cfg = cfg.WithDefaults()
+	p := &Proxy{
+		name:           cfg.Name,
+		addr:           ":" + strconv.Itoa(cfg.ListenPort),
+		pool:           pool,
+		connectTimeout: time.Duration(cfg.ConnectTimeoutMs) * time.Millisecond,
+		idleTimeout:    time.Duration(cfg.IdleTimeoutMs) * time.Millisecond,
+		maxPerBackend:  int64(cfg.MaxPerBackend),
+		sendProxy:      cfg.SendProxyProtocol,
+		sessions:       make(map[*session]struct{}),
+	}
+	if cfg.MaxConnections > 0 {
+		p.slots = make(chan struct{}, cfg.MaxConnections)
+	}
+	return p
+}
+
+func (p *Proxy) Name() string { return p.name }
+func (p *Proxy) Addr() string { return p.addr }
+
+func (p *Proxy) ListenAndServe() error {
+	ln, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(ln)
+}
+
+// Принимает соединения из ln до Shutdown или Close, после них возвращает ErrProxyClosed
+func (p *Proxy) Serve(ln net.Listener) error {
+	p.mu.Lock()
+	if p.closing {
+		p.mu.Unlock()
+		ln.Close()
+		return ErrProxyClosed
+	}
+	p.listener = ln
+	p.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return ErrProxyClosed
+			}
+			// Например, закончились файловые дескрипторы: ждем, как http.Server
+			log.Printf("TCP %s: accept error: %v", p.name, err)
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+
+		if p.slots != nil {
+			select {
+			case p.slots <- struct{}{}:
+			default:
+				metrics.TCPRejected.Inc(p.name, "max_connections")
+				conn.Close()
+				continue
+			}
+		}
+
+		p.mu.Lock()
+		if p.closing {
+			p.mu.Unlock()
+			p.release()
+			conn.Close()
+			return ErrProxyClosed
+		}
+		p.wg.Add(1)
+		p.mu.Unlock()
+
+		go p.handle(conn)
+	}
+}
+
+func (p *Proxy) release() {
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+func (p *Proxy) handle(client net.Conn) {
+	defer p.wg.Done()
+	defer p.release()
+
+	start := time.Now()
+	b, upstream, err := p.connect(client)
+	if err != nil {
+		metrics.TCPRejected.Inc(p.name, "no_backend")
+		log.Printf("TCP %s: connection from %s not proxied: %v", p.name, client.RemoteAddr(), err)
+		client.Close()
+		return
+	}
+	defer b.DecConn()
+
+	s := newSession(p.name, client, upstream, p.idleTimeout)
+	if !p.track(s) {
+		s.close()
+		return
+	}
+	b.TrackTCP(s)
+	// Бэкэнд мог перейти в drain, пока устанавливалось соединение
+	if b.IsDraining() {
+		s.close()
+	}
+	sent, received := s.run()
+	b.UntrackTCP(s)
+	p.untrack(s)
+
+	metrics.TCPBytes.Add(float64(sent), p.name, "sent")
+	metrics.TCPBytes.Add(float64(received), p.name, "received")
+	log.Printf("TCP %s: %s <-> %s closed after %s, sent %d bytes, received %d bytes",
+		p.name, client.RemoteAddr(), b.URL.Host, time.Since(start).Round(time.Millisecond), sent, received)
+}
+
+// Соединяется с бэкэндом, выбранным стратегией. Если соединение не установилось, пробуется
+// следующий бэкэнд: клиент еще ничего не отправил, поэтому повтор безопасен. Возвращенный
+// бэкэнд уже учитывает соединение (IncConn)
+func (p *Proxy) connect(client net.Conn) (*backend.Backend, net.Conn, error) {
+	// Стратегии выбирают бэкэнд по запросу, для consistent_hash ключом служит IP клиента
+	req := &http.Request{RemoteAddr: client.RemoteAddr().String(), Header: http.Header{}, URL: &url.URL{}}
+	tried := make(map[*backend.Backend]struct{})
+
+	err := ErrNoAvailableBackends
+	for attempt := 0; attempt < maxConnectAttempts; {
+		b := p.pool.NextBackendExcluding(req, tried)
+		if b == nil {
+			return nil, nil, err
+		}
+		tried[b] = struct{}{}
+
+		if !b.Breaker.Allow() {
+			err = ErrCircuitOpen
+			continue
+		}
+		if !b.TryIncConn(p.maxPerBackend) {
+			b.Breaker.Release()
+			metrics.TCPRejected.Inc(p.name, "max_per_backend")
+			err = ErrBackendLimit
+			continue
+		}
+		attempt++
+
+		upstream, dialErr := p.dial(b, client)
+		if dialErr != nil {
+			b.DecConn()
+			b.Breaker.Record(false)
+			p.pool.ReportResult(b, false)
+			log.Printf("TCP %s: connect to %s failed: %v", p.name, b.URL.Host, dialErr)
+			err = dialErr
+			continue
+		}
+		b.Breaker.Record(true)
+		p.pool.ReportResult(b, true)
+		metrics.TCPConnections.Inc(p.name, b.URL.String())
+		return b, upstream, nil
+	}
+	return nil, nil, err
+}
+
+// Соединяется с бэкэндом и, если нужно, передает ему адрес клиента заголовком PROXY protocol
+func (p *Proxy) dial(b *backend.Backend, client net.Conn) (net.Conn, error) {
+	upstream, err := net.DialTimeout("tcp", b.URL.Host, p.connectTimeout)
+	if err != nil || p.sendProxy == 0 {
+		return upstream, err
+	}
+
+	upstream.SetWriteDeadline(time.Now().Add(p.connectTimeout))
+	if err := proxyproto.WriteHeader(upstream, p.sendProxy, client.RemoteAddr(), client.LocalAddr()); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+	upstream.SetWriteDeadline(time.Time{})
+	return upstream, nil
+}
+
+func (p *Proxy) track(s *session) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.sessions[s] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(s *session) {
+	p.mu.Lock()
+	delete(p.sessions, s)
+	p.mu.Unlock()
+}
+
+// Перестает принимать соединения и ждет, пока закроются начатые. Соединения без трафика
+// закрываются по мере простоя, а если ctx истек раньше, оставшиеся закрываются принудительно
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closing = true
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		p.closeIdle()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			p.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Proxy) closeIdle() {
+	p.mu.Lock()
+	var idle []*session
+	for s := range p.sessions {
+		if s.idle() >= shutdownIdle {
+			idle = append(idle, s)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, s := range idle {
+		s.close()
+	}
+}
+
+// Закрывает listener и все соединения
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closing = true
+	p.closed = true
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	sessions := make([]*session, 0, len(p.sessions))
+	for s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.mu.Unlock()
+
+	for _, s := range sessions {
+		s.close()
+	}
+	return nil
+}
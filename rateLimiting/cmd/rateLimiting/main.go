@@ -5,14 +5,21 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"rateLimiting/pkg/config"
 	"rateLimiting/pkg/db"
 	"rateLimiting/pkg/handlers"
+	"rateLimiting/pkg/metrics"
 	"rateLimiting/pkg/middleware"
+	"rateLimiting/pkg/proxyproto"
+	"rateLimiting/pkg/response"
 	"rateLimiting/pkg/token"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,6 +30,9 @@ func main() {
 	cfgPath := flag.String("config", "config.json", "Path to config file")
 	flag.Parse()
 
+	// Все логи, включая log.Printf, пишутся в JSON через slog
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	cfg, err := config.LoadConfig(*cfgPath)
 	if err != nil {
 		log.Fatalf("Не удалось загрузить конфиг: %v", err)
@@ -49,10 +59,18 @@ func main() {
 		portDB = "5432"
 	}
 
+	// Адреса балансировщиков зависят от окружения, поэтому их можно задать без пересборки образа
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		cfg.TrustedProxies = strings.Split(proxies, ",")
+	}
+
 	db := db.NewDB(userNameDB, passwordDB, nameDB, hostDB, portDB)
-	defer func() { db.Db.Close() }()
 
 	rateLimiter := token.NewRateLimiter()
+	metrics.ClientRequests.SetTopN(cfg.MetricsTopClients)
+	metrics.NewGaugeFunc("rl_buckets", "Token buckets held by rate limiter", nil, func(set func(float64, ...string)) {
+		set(float64(rateLimiter.BucketsCount()))
+	})
 
 	userHandler := &handlers.UserHandler{
 		ClientRepo: rateLimiter,
@@ -64,7 +82,11 @@ func main() {
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	go rateLimiter.StartRefillTicker(ctx, time.Duration(cfg.RefillInterval)*time.Second)
+	refillDone := make(chan struct{})
+	go func() {
+		defer close(refillDone)
+		rateLimiter.StartRefillTicker(ctx, time.Duration(cfg.RefillInterval)*time.Second)
+	}()
 
 	r := mux.NewRouter()
 	r.Use(middleware.RateLimitMiddleware(rateLimiter, cfg.BucketDefaultCapacity, cfg.DefaultRefillRate, db))
@@ -75,18 +97,93 @@ func main() {
 	r.HandleFunc("/api/client/{CLIENT_ID}", userHandler.DeleteClient).Methods(http.MethodDelete)
 	r.HandleFunc("/api/client/{CLIENT_ID}", userHandler.EditClient).Methods(http.MethodPut)
 
+	// Readiness не должен расходовать токены клиента, поэтому висит вне роутера с rate limit
+	ready := &atomic.Bool{}
+	root := http.NewServeMux()
+	root.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			response.ResponseJSON(w, http.StatusServiceUnavailable, "shutting down")
+			return
+		}
+		response.ResponseJSON(w, http.StatusOK, "ready")
+	})
+	root.Handle("/", r)
+
+	trustedProxies, err := proxyproto.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Некорректный trusted_proxies: %v", err)
+	}
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.ListenPort),
+		Handler: middleware.RealIP(trustedProxies)(middleware.LoggingMiddleware(root)),
+	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatalf("Listen(): %v", err)
+	}
+	// За L4 балансировщиком адрес клиента приходит в заголовке PROXY protocol
+	if len(cfg.ProxyProtocol.TrustedCIDRs) > 0 {
+		trusted, err := proxyproto.ParseCIDRs(cfg.ProxyProtocol.TrustedCIDRs)
+		if err != nil {
+			log.Fatalf("Некорректный proxy_protocol.trusted_cidrs: %v", err)
+		}
+		ln = proxyproto.NewListener(ln, trusted, time.Duration(cfg.ProxyProtocol.HeaderTimeoutMs)*time.Millisecond)
+	}
+
 	go func() {
-		addr := fmt.Sprintf(":%d", cfg.ListenPort)
-		log.Printf("Запуск Rate Limiting на %s ...", addr)
-		if err := http.ListenAndServe(addr, r); err != nil && err != http.ErrServerClosed {
+		log.Printf("Запуск Rate Limiting на %s ...", srv.Addr)
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("ListenAndServe(): %v", err)
 		}
 	}()
 
+	// Метрики содержат IP клиентов, поэтому отдаются на отдельном порту, а не на публичном
+	var metricsServer *http.Server
+	if cfg.MetricsListenPort != 0 {
+		metricsServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.MetricsListenPort),
+			Handler: metrics.Handler(),
+		}
+		go func() {
+			log.Printf("Запуск метрик Rate Limiting на %s ...", metricsServer.Addr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("ListenAndServe(): %v", err)
+			}
+		}()
+	}
+	ready.Store(true)
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	cancel()
 	log.Println("Завершение работы Rate Limiting...")
+
+	// Проваливаем readiness, перестаем принимать соединения и ждем начатые запросы
+	// не дольше shutdown_timeout, затем останавливаем пополнение токенов и закрываем БД
+	ready.Store(false)
+	exitCode := 0
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Не все запросы завершились до истечения shutdown_timeout: %v", err)
+		srv.Close()
+		exitCode = 1
+	}
+	shutdownCancel()
+	// Метрики отдаются до конца, чтобы был виден ход завершения
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+
+	cancel()
+	<-refillDone
+
+	if err := db.Db.Close(); err != nil {
+		log.Printf("Ошибка при закрытии соединений с БД: %v", err)
+		exitCode = 1
+	}
+
 	log.Println("Rate Limiting завершил работу")
+	os.Exit(exitCode)
 }
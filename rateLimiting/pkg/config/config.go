@@ -6,12 +6,31 @@ import (
 )
 
 type Config struct {
-	RefillInterval        int     `json:"refill_interval"`
-	ListenPort            int     `json:"listen_port"`
-	BucketDefaultCapacity float64 `json:"bucket_default_capacity"`
-	DefaultRefillRate     float64 `json:"default_refill_rate"`
+	RefillInterval        int                 `json:"refill_interval"`
+	ListenPort            int                 `json:"listen_port"`
+	BucketDefaultCapacity float64             `json:"bucket_default_capacity"`
+	DefaultRefillRate     float64             `json:"default_refill_rate"`
+	ShutdownTimeout       int                 `json:"shutdown_timeout"`
+	MetricsTopClients     int                 `json:"metrics_top_clients"`
+	MetricsListenPort     int                 `json:"metrics_listen_port"`
+	ProxyProtocol         ProxyProtocolConfig `json:"proxy_protocol"`
+	TrustedProxies        []string            `json:"trusted_proxies"`
 }
 
+// PROXY protocol (v1 и v2) на входящих соединениях: если сервис стоит за L4 балансировщиком,
+// адрес клиента берется из заголовка PROXY. Заголовок принимается только от адресов из
+// trusted_cidrs (пустой список - выключено), соединения без заголовка работают как обычно
+type ProxyProtocolConfig struct {
+	TrustedCIDRs    []string `json:"trusted_cidrs"`
+	HeaderTimeoutMs int      `json:"header_timeout_ms"`
+}
+
+const (
+	defaultShutdownTimeout   = 30
+	defaultMetricsTopClients = 10
+	defaultHeaderTimeoutMs   = 5000
+)
+
 func LoadConfig(filePath string) (*Config, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
@@ -24,5 +43,15 @@ func LoadConfig(filePath string) (*Config, error) {
 		return nil, err
 	}
 
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if cfg.MetricsTopClients <= 0 {
+		cfg.MetricsTopClients = defaultMetricsTopClients
+	}
+	if cfg.ProxyProtocol.HeaderTimeoutMs <= 0 {
+		cfg.ProxyProtocol.HeaderTimeoutMs = defaultHeaderTimeoutMs
+	}
+
 	return &cfg, nil
 }
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"rateLimiting/pkg/metrics"
 	"rateLimiting/pkg/token"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -35,7 +37,9 @@ func (db *DB) UpdateOrInsertClient(clientIP string, capacity, refillRate float64
 		ON CONFLICT (client_ip)
 		DO UPDATE SET capacity = EXCLUDED.capacity, rate = EXCLUDED.rate;
 	`
+	start := time.Now()
 	_, err := db.Db.Exec(query, clientIP, capacity, refillRate)
+	observeQuery("upsert_client", start, err)
 	if err != nil {
 		return ErrCantWriteInDB
 	}
@@ -48,7 +52,9 @@ func (db *DB) DeleteClient(clientIP string) error {
 		DELETE FROM clients_info
 		WHERE client_ip = $1;
 	`
+	start := time.Now()
 	_, err := db.Db.Exec(query, clientIP)
+	observeQuery("delete_client", start, err)
 	if err != nil {
 		return ErrCantDeleteFromDB
 	}
@@ -56,7 +62,10 @@ func (db *DB) DeleteClient(clientIP string) error {
 
 }
 
-func (db *DB) LoadClientsFromDB(rateLimiter *token.RateLimiter) error {
+func (db *DB) LoadClientsFromDB(rateLimiter *token.RateLimiter) (err error) {
+	start := time.Now()
+	defer func() { observeQuery("load_clients", start, err) }()
+
 	rows, err := db.Db.Query("SELECT client_ip, capacity, rate FROM clients_info")
 	if err != nil {
 		return err
@@ -74,3 +83,10 @@ func (db *DB) LoadClientsFromDB(rateLimiter *token.RateLimiter) error {
 
 	return rows.Err()
 }
+
+func observeQuery(operation string, start time.Time, err error) {
+	metrics.DBQueryDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil {
+		metrics.DBErrors.Inc(operation)
+	}
+}
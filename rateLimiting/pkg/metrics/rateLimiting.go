@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	Requests = NewCounterVec("rl_requests_total",
+		"Requests checked by rate limiter", "result")
+	RefillDuration = NewHistogramVec("rl_refill_duration_seconds",
+		"Duration of one refill pass over all buckets", DefBuckets)
+	DBQueryDuration = NewHistogramVec("rl_db_query_duration_seconds",
+		"Database query duration", DefBuckets, "operation")
+	DBErrors = NewCounterVec("rl_db_errors_total",
+		"Database query errors", "operation")
+
+	ClientRequests = NewTopClients("rl_client_requests_total",
+		"Requests checked by rate limiter per client, clients outside top-N are summed into client=\"other\"", 10)
+)
+
+const (
+	ResultAllowed  = "allowed"
+	ResultRejected = "rejected"
+
+	otherClients = "other"
+	// Идентификатор клиента (IP) задает сам клиент, поэтому число отдельно учитываемых
+	// клиентов ограничено, запросы новых клиентов сверх лимита считаются сразу в "other"
+	maxTrackedClients = 10000
+)
+
+// Счетчик запросов по клиентам с ограниченной кардинальностью меток: в /metrics
+// попадают только top-N клиентов по числу запросов, остальные суммируются в "other".
+// В памяти хранится не больше maxClients клиентов, новые клиенты сверх лимита идут в "other"
+type TopClients struct {
+	name       string
+	help       string
+	topN       int
+	maxClients int
+	clients    map[string]*clientCounts
+	mu         sync.Mutex
+}
+
+type clientCounts struct {
+	allowed  uint64
+	rejected uint64
+}
+
+func NewTopClients(name, help string, topN int) *TopClients {
+	t := &TopClients{
+		name:       name,
+		help:       help,
+		topN:       topN,
+		maxClients: maxTrackedClients,
+		clients:    make(map[string]*clientCounts),
+	}
+	Default.Register(t)
+	return t
+}
+
+func (t *TopClients) SetTopN(topN int) {
+	t.mu.Lock()
+	t.topN = topN
+	t.mu.Unlock()
+}
+
+func (t *TopClients) Inc(clientID string, allowed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clients[clientID]
+	if !ok {
+		if len(t.clients) >= t.maxClients {
+			clientID = otherClients
+		}
+		if c, ok = t.clients[clientID]; !ok {
+			c = &clientCounts{}
+			t.clients[clientID] = c
+		}
+	}
+	if allowed {
+		c.allowed++
+	} else {
+		c.rejected++
+	}
+}
+
+// Клиент удален, его счетчики переносятся в "other", чтобы сумма не уменьшалась
+func (t *TopClients) Delete(clientID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clients[clientID]
+	if !ok || clientID == otherClients {
+		return
+	}
+	delete(t.clients, clientID)
+
+	other, ok := t.clients[otherClients]
+	if !ok {
+		other = &clientCounts{}
+		t.clients[otherClients] = other
+	}
+	other.allowed += c.allowed
+	other.rejected += c.rejected
+}
+
+func (t *TopClients) Write(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]string, 0, len(t.clients))
+	for id := range t.clients {
+		if id != otherClients {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		ci, cj := t.clients[ids[i]], t.clients[ids[j]]
+		ti, tj := ci.allowed+ci.rejected, cj.allowed+cj.rejected
+		if ti != tj {
+			return ti > tj
+		}
+		return ids[i] < ids[j]
+	})
+
+	other := clientCounts{}
+	if c, ok := t.clients[otherClients]; ok {
+		other = *c
+	}
+	if len(ids) > t.topN {
+		for _, id := range ids[t.topN:] {
+			other.allowed += t.clients[id].allowed
+			other.rejected += t.clients[id].rejected
+		}
+		ids = ids[:t.topN]
+	}
+
+	writeHeader(w, t.name, t.help, "counter")
+	labels := []string{"client", "result"}
+	write := func(client string, c clientCounts) {
+		fmt.Fprintf(w, "%s%s %d\n", t.name, formatLabels(labels, []string{client, ResultAllowed}), c.allowed)
+		fmt.Fprintf(w, "%s%s %d\n", t.name, formatLabels(labels, []string{client, ResultRejected}), c.rejected)
+	}
+	for _, id := range ids {
+		write(id, *t.clients[id])
+	}
+	if other.allowed+other.rejected > 0 {
+		write(otherClients, other)
+	}
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestTopClients(topN, maxClients int) *TopClients {
+	return &TopClients{
+		name:       "test_client_requests_total",
+		topN:       topN,
+		maxClients: maxClients,
+		clients:    make(map[string]*clientCounts),
+	}
+}
+
+// Строки с отсчетами без HELP и TYPE
+func samples(t *TopClients) []string {
+	var sb strings.Builder
+	t.Write(&sb)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(sb.String()), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestTopClientsMaxTracked(t *testing.T) {
+	tc := newTestTopClients(10, 2)
+	tc.Inc("10.0.0.1", true)
+	tc.Inc("10.0.0.2", false)
+	// Лимит отдельно учитываемых клиентов достигнут, новые клиенты идут в "other"
+	tc.Inc("10.0.0.3", true)
+	tc.Inc("10.0.0.4", true)
+	// Уже известные клиенты продолжают считаться отдельно
+	tc.Inc("10.0.0.1", true)
+
+	if len(tc.clients) != 3 {
+		t.Fatalf("tracked %d clients, want 2 and other", len(tc.clients))
+	}
+	want := []string{
+		`test_client_requests_total{client="10.0.0.1",result="allowed"} 2`,
+		`test_client_requests_total{client="10.0.0.1",result="rejected"} 0`,
+		`test_client_requests_total{client="10.0.0.2",result="allowed"} 0`,
+		`test_client_requests_total{client="10.0.0.2",result="rejected"} 1`,
+		`test_client_requests_total{client="other",result="allowed"} 2`,
+		`test_client_requests_total{client="other",result="rejected"} 0`,
+	}
+	if got := samples(tc); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTopClientsTopN(t *testing.T) {
+	tc := newTestTopClients(1, maxTrackedClients)
+	for i := 0; i < 3; i++ {
+		tc.Inc("busy", true)
+	}
+	tc.Inc("quiet", false)
+	tc.Inc("deleted", true)
+	tc.Delete("deleted")
+	// Удаленный клиент учитывается заново, а не в "other"
+	tc.Inc("deleted", true)
+
+	want := []string{
+		`test_client_requests_total{client="busy",result="allowed"} 3`,
+		`test_client_requests_total{client="busy",result="rejected"} 0`,
+		`test_client_requests_total{client="other",result="allowed"} 2`,
+		`test_client_requests_total{client="other",result="rejected"} 1`,
+	}
+	if got := samples(tc); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"rateLimiting/pkg/db"
+	"rateLimiting/pkg/metrics"
 	"rateLimiting/pkg/token"
 
 	"rateLimiting/pkg/response"
@@ -20,23 +21,26 @@ func RateLimitMiddleware(rateLimiter *token.RateLimiter, capacity, refillRate fl
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			clientIP := getClientIP(r)
 			db.UpdateOrInsertClient(clientIP, capacity, refillRate)
-			if !rateLimiter.AllowRequest(clientIP, capacity, refillRate) {
+			allowed := rateLimiter.AllowRequest(clientIP, capacity, refillRate)
+			metrics.ClientRequests.Inc(clientIP, allowed)
+			if !allowed {
+				metrics.Requests.Inc(metrics.ResultRejected)
 				w.WriteHeader(http.StatusTooManyRequests)
 				response.ResponseJSON(w, http.StatusTooManyRequests, ErrTooManyRequests.Error())
 				return
 			}
+			metrics.Requests.Inc(metrics.ResultAllowed)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// Адрес клиента без порта: порт меняется от соединения к соединению, лимит считается по адресу.
+// При PROXY protocol здесь уже адрес клиента из заголовка, заголовки X-Real-IP и X-Forwarded-For
+// от доверенных прокси учитывает middleware RealIP
 func getClientIP(r *http.Request) string {
-	ip := r.Header.Get("X-Real-IP")
-	if ip == "" {
-		ip = r.Header.Get("X-Forwarded-For")
+	if ip := remoteIP(r); ip != nil {
+		return ip.String()
 	}
-	if ip == "" {
-		ip = r.RemoteAddr
-	}
-	return ip
+	return r.RemoteAddr
 }
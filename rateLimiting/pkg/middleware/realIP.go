@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Middleware, который подставляет в RemoteAddr адрес клиента из X-Real-IP или X-Forwarded-For.
+// Заголовки учитываются, только если запрос пришел от доверенного прокси (trusted), иначе
+// клиент мог бы подменить свой адрес и обходить rate limit. Пустой trusted - заголовки игнорируются
+func RealIP(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedIP(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func forwardedIP(r *http.Request, trusted []*net.IPNet) string {
+	if !isTrusted(remoteIP(r), trusted) {
+		return ""
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	// Каждый прокси дописывает адрес, от которого получил запрос, в конец X-Forwarded-For,
+	// поэтому клиент - самый правый адрес, не принадлежащий доверенным прокси. Адреса левее
+	// мог прислать сам клиент
+	var values []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		values = append(values, strings.Split(h, ",")...)
+	}
+	for i := len(values) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(values[i]))
+		if ip == nil {
+			return ""
+		}
+		if !isTrusted(ip, trusted) || i == 0 {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
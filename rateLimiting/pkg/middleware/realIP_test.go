@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rateLimiting/pkg/proxyproto"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := proxyproto.ParseCIDRs([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		xff        []string
+		trusted    bool
+		// Адрес клиента, по которому считается лимит
+		want string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.5:4000", want: "203.0.113.5"},
+		{name: "untrusted sender headers ignored", remoteAddr: "203.0.113.5:4000", realIP: "1.1.1.1", xff: []string{"2.2.2.2"}, want: "203.0.113.5"},
+		{name: "trusted x-real-ip", remoteAddr: "10.0.0.2:4000", realIP: "198.51.100.7", want: "198.51.100.7"},
+		{name: "x-real-ip before x-forwarded-for", remoteAddr: "10.0.0.2:4000", realIP: "198.51.100.7", xff: []string{"198.51.100.8"}, want: "198.51.100.7"},
+		{name: "trusted ipv6 loopback", remoteAddr: "[::1]:4000", xff: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		// Левые адреса мог прислать сам клиент, берется самый правый недоверенный
+		{name: "spoofed left entry", remoteAddr: "10.0.0.2:4000", xff: []string{"1.1.1.1, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "trusted proxies skipped", remoteAddr: "10.0.0.2:4000", xff: []string{"198.51.100.7, 10.0.0.3", "10.0.0.4"}, want: "198.51.100.7"},
+		{name: "only trusted entries", remoteAddr: "10.0.0.2:4000", xff: []string{"10.0.0.5, 10.0.0.3"}, want: "10.0.0.5"},
+		{name: "invalid entry", remoteAddr: "10.0.0.2:4000", xff: []string{"198.51.100.7, garbage"}, want: "10.0.0.2"},
+		{name: "invalid x-real-ip falls back to x-forwarded-for", remoteAddr: "10.0.0.2:4000", realIP: "garbage", xff: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "trusted without headers", remoteAddr: "10.0.0.2:4000", want: "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = getClientIP(r)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("client ip = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealIPWithoutTrustedProxies(t *testing.T) {
+	var got string
+	handler := RealIP(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = getClientIP(r)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "127.0.0.1:4000"
+	req.Header.Set("X-Real-IP", "198.51.100.7")
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "127.0.0.1" {
+		t.Errorf("client ip = %q, want 127.0.0.1", got)
+	}
+}
+
+// Порт не входит в ключ клиента, иначе каждое новое соединение получало бы свой лимит
+func TestGetClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{remoteAddr: "203.0.113.5:4000", want: "203.0.113.5"},
+		{remoteAddr: "203.0.113.5:4001", want: "203.0.113.5"},
+		{remoteAddr: "[2001:db8::1]:4000", want: "2001:db8::1"},
+		{remoteAddr: "203.0.113.5", want: "203.0.113.5"},
+		{remoteAddr: "not an address", want: "not an address"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if got := getClientIP(req); got != tt.want {
+			t.Errorf("getClientIP(%q) = %q, want %q", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Заголовок v2 с командой PROXY, семейством family и длиной блока адресов length
+// (может не совпадать с длиной payload, чтобы проверить обрезанный ввод)
+func v2Header(family byte, length int, payload []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, 0x21, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(length))
+	return append(h, payload...)
+}
+
+// Блок адресов TCP over IPv4: 10.0.0.1:1000 -> 10.0.0.2:2000
+var v2IPv4Payload = []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x03, 0xe8, 0x07, 0xd0}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		src     string
+		dst     string
+		wantErr error
+		// Данные после заголовка должны остаться в reader
+		rest string
+	}{
+		{name: "no header", input: []byte("GET / HTTP/1.1\r\n"), rest: "GET / HTTP/1.1\r\n"},
+		{name: "empty connection", input: nil},
+		{name: "v1 tcp4", input: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nhello"), src: "192.168.0.1:56324", dst: "192.168.0.11:443", rest: "hello"},
+		{name: "v1 tcp6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n"), src: "[2001:db8::1]:1", dst: "[2001:db8::2]:2"},
+		{name: "v1 unknown", input: []byte("PROXY UNKNOWN\r\nhello"), rest: "hello"},
+		{name: "v1 truncated", input: []byte("PROXY TCP4 192.168.0.1 192.168"), wantErr: io.EOF},
+		{name: "v1 without crlf", input: []byte("PROXY TCP4 1.1.1.1 2.2.2.2 1 2\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 oversized", input: []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 bad port", input: []byte("PROXY TCP4 1.1.1.1 2.2.2.2 1 70000\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 bad address", input: []byte("PROXY TCP4 1.1.1 2.2.2.2 1 2\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v1 missing fields", input: []byte("PROXY TCP4 1.1.1.1 2.2.2.2 1\r\n"), wantErr: ErrInvalidHeader},
+		{name: "v2 tcp4", input: append(v2Header(0x11, 12, v2IPv4Payload), "hello"...), src: "10.0.0.1:1000", dst: "10.0.0.2:2000", rest: "hello"},
+		{name: "v2 tlv skipped", input: append(v2Header(0x11, 12+7, append(append([]byte{}, v2IPv4Payload...), 0x04, 0, 4, 'a', 'b', 'c', 'd')), "hello"...), src: "10.0.0.1:1000", dst: "10.0.0.2:2000", rest: "hello"},
+		{name: "v2 large tlv", input: append(v2Header(0x11, 12+4096, append(append([]byte{}, v2IPv4Payload...), make([]byte, 4096)...)), "hello"...), src: "10.0.0.1:1000", dst: "10.0.0.2:2000", rest: "hello"},
+		{name: "v2 local", input: append(append(append([]byte{}, v2Signature...), 0x20, 0x00, 0, 0), "hello"...), rest: "hello"},
+		{name: "v2 unix family ignored", input: v2Header(0x31, 4, []byte{1, 2, 3, 4})},
+		{name: "v2 truncated fixed header", input: append(append([]byte{}, v2Signature...), 0x21, 0x11), wantErr: io.ErrUnexpectedEOF},
+		{name: "v2 truncated payload", input: v2Header(0x11, 12, v2IPv4Payload[:6]), wantErr: io.ErrUnexpectedEOF},
+		{name: "v2 length beyond input", input: v2Header(0x11, 0xffff, v2IPv4Payload), wantErr: io.ErrUnexpectedEOF},
+		{name: "v2 address block too short", input: v2Header(0x11, 6, v2IPv4Payload[:6]), wantErr: ErrInvalidHeader},
+		{name: "v2 bad version", input: append(append(append([]byte{}, v2Signature...), 0x11, 0x11, 0, 12), v2IPv4Payload...), wantErr: ErrUnsupportedVersion},
+		{name: "v2 bad command", input: append(append(append([]byte{}, v2Signature...), 0x22, 0x11, 0, 12), v2IPv4Payload...), wantErr: ErrInvalidHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.input))
+			src, dst, err := readHeader(r)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := addrString(src); got != tt.src {
+				t.Errorf("src = %s, want %s", got, tt.src)
+			}
+			if got := addrString(dst); got != tt.dst {
+				t.Errorf("dst = %s, want %s", got, tt.dst)
+			}
+			rest, _ := io.ReadAll(r)
+			if string(rest) != tt.rest {
+				t.Errorf("rest = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
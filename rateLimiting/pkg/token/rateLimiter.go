@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log"
 	"math"
+	"rateLimiting/pkg/metrics"
 	"sync"
 	"time"
 )
@@ -44,6 +45,12 @@ func (rl *RateLimiter) GetOrCreateBucket(clientID string, capacity, refillRate f
 	return bucket
 }
 
+func (rl *RateLimiter) BucketsCount() int {
+	rl.RLock()
+	defer rl.RUnlock()
+	return len(rl.buckets)
+}
+
 func (rl *RateLimiter) AllowRequest(clientID string, capacity, refillRate float64) bool {
 	bucket := rl.GetOrCreateBucket(clientID, capacity, refillRate)
 	return bucket.Allow()
@@ -55,6 +62,7 @@ func (rl *RateLimiter) DeleteClient(clientID string) error {
 
 	if _, ok := rl.buckets[clientID]; ok {
 		delete(rl.buckets, clientID)
+		metrics.ClientRequests.Delete(clientID)
 		return nil
 	}
 
@@ -101,11 +109,13 @@ func (rl *RateLimiter) StartRefillTicker(ctx context.Context, interval time.Dura
 			return
 
 		case <-ticker.C:
+			start := time.Now()
 			rl.Lock()
 			for _, bucket := range rl.buckets {
 				bucket.Refill()
 			}
 			rl.Unlock()
+			metrics.RefillDuration.Observe(time.Since(start).Seconds())
 		}
 	}
 }